@@ -0,0 +1,66 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+
+	"github.com/fabio42/ssl-checker/domains"
+
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var checkCmd = &cobra.Command{
+	Use:   "check",
+	Short: "Run the configured queries without user interface",
+	Long: "Run the configured queries without starting the user interface, results are streamed to stderr as they " +
+		"arrive and the report is written to stdout (or --output), the exit code follows the warn/crit thresholds.",
+	Run: func(cmd *cobra.Command, args []string) {
+		if noConfig || !viper.IsSet("queries") {
+			log.Error().Msg("No queries found in configuration")
+			os.Exit(int(domains.StatusUnknown))
+		}
+		viper.Set("silent", true)
+		runQueries(configQueries(envCheck))
+	},
+}
+
+// runCheck runs the probes headlessly, then writes the report and exits with
+// the Nagios status of the run
+func runCheck(ctx context.Context, runner *domains.Runner, thresholds map[string]domains.Thresholds) {
+	var total int
+	for _, t := range runner.Totals() {
+		total += t
+	}
+	fmt.Fprintf(os.Stderr, "Processing %d targets!\n", total)
+
+	resps := []domains.Response{}
+	for r := range runner.Start(ctx) {
+		resps = append(resps, r)
+		if r.Error != nil && r.HasCert() {
+			fmt.Fprintf(os.Stderr, "[%d/%d] %s %s: expires %s, error: %s\n", len(resps), total, r.Environment, r.Endpoint(), r.Expiry().Format("2006-01-02"), r.KnownError())
+		} else if r.Error != nil {
+			fmt.Fprintf(os.Stderr, "[%d/%d] %s %s: error: %s\n", len(resps), total, r.Environment, r.Endpoint(), r.KnownError())
+		} else {
+			fmt.Fprintf(os.Stderr, "[%d/%d] %s %s: expires %s\n", len(resps), total, r.Environment, r.Endpoint(), r.Expiry().Format("2006-01-02"))
+		}
+	}
+
+	output := viper.GetString("output")
+	err := domains.CreateReport(resps, runner.Environments(), output, viper.GetString("format"), output == "")
+	if err != nil {
+		log.Error().Msgf("Error while writing report: %v", err)
+		os.Exit(int(domains.StatusUnknown))
+	}
+
+	summary := domains.Evaluate(resps, thresholds, globalThresholds())
+	fmt.Fprintln(os.Stderr, summary)
+	os.Exit(int(summary.Status))
+}
+
+func init() {
+	checkCmd.Flags().StringVarP(&envCheck, "environments", "e", "", "Comma delimited string specifying the environments to check")
+	rootCmd.AddCommand(checkCmd)
+}
@@ -0,0 +1,248 @@
+package cmd
+
+import (
+	"crypto/tls"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/fabio42/ssl-checker/domains"
+
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/viper"
+)
+
+// queryConfig holds the targets and settings of the environments to check
+type queryConfig struct {
+	files      map[string]string
+	domains    map[string][]string
+	thresholds map[string]domains.Thresholds
+	trust      map[string]trustConfig
+	clients    map[string]clientConfig
+	proxies    map[string]string
+	resolvers  map[string]string
+}
+
+func newQueryConfig() *queryConfig {
+	return &queryConfig{
+		files:      map[string]string{},
+		domains:    map[string][]string{},
+		thresholds: map[string]domains.Thresholds{},
+		trust:      map[string]trustConfig{},
+		clients:    map[string]clientConfig{},
+		proxies:    map[string]string{},
+		resolvers:  map[string]string{},
+	}
+}
+
+// trustConfig is the CA file and directory certificates are verified against
+type trustConfig struct {
+	caFile   string
+	caDir    string
+	caAppend bool
+}
+
+// globalTrust returns the trust settings set by flags or top level configuration
+func globalTrust() trustConfig {
+	return trustConfig{
+		caFile:   viper.GetString("ca_file"),
+		caDir:    viper.GetString("ca_dir"),
+		caAppend: viper.GetBool("ca_append"),
+	}
+}
+
+// parseTrust reads the trust settings of an environment, keeping def values when unset
+func parseTrust(env string, def trustConfig, data map[string]interface{}) trustConfig {
+	t := def
+	if data["ca_file"] != nil || data["ca_dir"] != nil {
+		t = trustConfig{}
+		if data["ca_file"] != nil {
+			t.caFile = fmt.Sprint(data["ca_file"])
+		}
+		if data["ca_dir"] != nil {
+			t.caDir = fmt.Sprint(data["ca_dir"])
+		}
+	}
+	if data["ca_append"] != nil {
+		appendSystem, ok := data["ca_append"].(bool)
+		if !ok {
+			log.Fatal().Msgf("Error in ca_append option for %s: %v is not a boolean", env, data["ca_append"])
+		}
+		t.caAppend = appendSystem
+	}
+	return t
+}
+
+// trustStore loads the roots of the trust settings, nil for the system ones
+func (t trustConfig) trustStore(env string) *domains.TrustStore {
+	if t.caFile == "" && t.caDir == "" {
+		return nil
+	}
+	store, err := domains.LoadTrustStore(t.caFile, t.caDir, t.caAppend)
+	if err != nil {
+		log.Fatal().Msgf("Error in trust store for %s: %v", env, err)
+	}
+	return store
+}
+
+// clientConfig is the client certificate sent to servers requiring mutual
+// TLS, either a PEM cert and key pair or a PKCS#12 bundle
+type clientConfig struct {
+	cert        string
+	key         string
+	p12         string
+	p12Password string
+}
+
+// globalClient returns the client certificate set by flags or top level configuration
+func globalClient() clientConfig {
+	return clientConfig{
+		cert:        viper.GetString("client_cert"),
+		key:         viper.GetString("client_key"),
+		p12:         viper.GetString("client_p12"),
+		p12Password: viper.GetString("client_p12_password"),
+	}
+}
+
+// parseClient reads the client certificate of an environment
+func parseClient(data map[string]interface{}) clientConfig {
+	c := clientConfig{}
+	for _, v := range []struct {
+		name string
+		dst  *string
+	}{{"client_cert", &c.cert}, {"client_key", &c.key}, {"client_p12", &c.p12}, {"client_p12_password", &c.p12Password}} {
+		if data[v.name] != nil {
+			*v.dst = fmt.Sprint(data[v.name])
+		}
+	}
+	return c
+}
+
+// clientCert loads the client certificate, nil when none is configured
+func (c clientConfig) clientCert(env string) *tls.Certificate {
+	var (
+		cert *tls.Certificate
+		err  error
+	)
+	switch {
+	case c.p12 != "" && (c.cert != "" || c.key != ""):
+		log.Fatal().Msgf("Error in client certificate for %s: client_p12 and client_cert/client_key are mutually exclusive", env)
+	case c.p12 != "":
+		cert, err = domains.LoadClientPKCS12(c.p12, c.p12Password)
+	case c.cert != "" && c.key != "":
+		cert, err = domains.LoadClientCertificate(c.cert, c.key)
+	case c.cert != "" || c.key != "":
+		log.Fatal().Msgf("Error in client certificate for %s: client_cert and client_key are both required", env)
+	}
+	if err != nil {
+		log.Fatal().Msgf("Error in client certificate for %s: %v", env, err)
+	}
+	return cert
+}
+
+// parseProxy validates a proxy URL, empty and direct are kept as is
+func parseProxy(env, raw string) string {
+	if raw == "" || raw == domains.ProxyDirect {
+		return raw
+	}
+	if _, err := domains.ParseProxy(raw); err != nil {
+		log.Fatal().Msgf("Error in proxy option for %s: %v", env, err)
+	}
+	return raw
+}
+
+// configQueries reads the queries option, envCheck optionally restricts the
+// environments to a comma delimited list
+func configQueries(envCheck string) *queryConfig {
+	envQuery := strings.Split(envCheck, ",")
+	qc := newQueryConfig()
+
+	for env, data := range viper.Get("queries").(map[string]interface{}) {
+		if envCheck != "" && !sliceContains(envQuery, env) {
+			continue
+		}
+		switch data := data.(type) {
+		case string:
+			qc.files[env] = data
+		case []interface{}:
+			qc.domains[env] = stringList(env, data)
+		case map[string]interface{}:
+			// Detailed form: file or domains plus per environment settings
+			switch {
+			case data["file"] != nil && data["domains"] != nil:
+				log.Fatal().Msgf("Error in query option for %s: file and domains are mutually exclusive", env)
+			case data["file"] != nil:
+				qc.files[env] = fmt.Sprint(data["file"])
+			case data["domains"] != nil:
+				list, ok := data["domains"].([]interface{})
+				if !ok {
+					log.Fatal().Msgf("Unsupported data type in query option for %s: domains is of type %T", env, data["domains"])
+				}
+				qc.domains[env] = stringList(env, list)
+			default:
+				log.Fatal().Msgf("Error in query option for %s: one of file or domains is required", env)
+			}
+			qc.thresholds[env] = parseThresholds(env, globalThresholds(), data["warn"], data["crit"])
+			if data["ca_file"] != nil || data["ca_dir"] != nil || data["ca_append"] != nil {
+				qc.trust[env] = parseTrust(env, globalTrust(), data)
+			}
+			if data["client_cert"] != nil || data["client_key"] != nil || data["client_p12"] != nil {
+				qc.clients[env] = parseClient(data)
+			}
+			if data["proxy"] != nil {
+				qc.proxies[env] = parseProxy(env, fmt.Sprint(data["proxy"]))
+			}
+			if data["resolver"] != nil {
+				qc.resolvers[env] = fmt.Sprint(data["resolver"])
+			}
+		default:
+			log.Fatal().Msgf("Unsupported data type in queries option: %v is of type %T", data, data)
+		}
+	}
+	log.Debug().Msgf("fileTargets is  : %v", qc.files)
+	log.Debug().Msgf("domainTargets is: %v", qc.domains)
+
+	return qc
+}
+
+// stringList converts a list of domains from the configuration
+func stringList(env string, data []interface{}) []string {
+	list := make([]string, len(data))
+	for k, domain := range data {
+		switch domain := domain.(type) {
+		case string:
+			list[k] = domain
+		default:
+			log.Fatal().Msgf("Unsupported data type in query option for %s: %v is of type %T", env, domain, domain)
+		}
+	}
+	return list
+}
+
+// globalThresholds returns the thresholds set by flags or top level configuration
+func globalThresholds() domains.Thresholds {
+	return parseThresholds("global", domains.DefaultThresholds(), viper.Get("warn"), viper.Get("crit"))
+}
+
+// parseThresholds reads warning and critical durations, keeping def values when unset
+func parseThresholds(env string, def domains.Thresholds, warn, crit interface{}) domains.Thresholds {
+	t := def
+	for _, v := range []struct {
+		name  string
+		value interface{}
+		dst   *time.Duration
+	}{{"warn", warn, &t.Warn}, {"crit", crit, &t.Crit}} {
+		if v.value == nil {
+			continue
+		}
+		d, err := domains.ParseDuration(fmt.Sprint(v.value))
+		if err != nil {
+			log.Fatal().Msgf("Error in %s option for %s: %v", v.name, env, err)
+		}
+		*v.dst = d
+	}
+	if t.Crit > t.Warn {
+		log.Fatal().Msgf("Error in thresholds for %s: crit (%v) is longer than warn (%v)", env, t.Crit, t.Warn)
+	}
+	return t
+}
@@ -1,11 +1,16 @@
 package cmd
 
 import (
+	"context"
 	"fmt"
 	"os"
+	"os/signal"
 	"path/filepath"
 	"strings"
+	"syscall"
+	"time"
 
+	"github.com/fabio42/ssl-checker/domains"
 	"github.com/fabio42/ssl-checker/ui"
 
 	tea "github.com/charmbracelet/bubbletea"
@@ -50,45 +55,13 @@ var rootCmd = &cobra.Command{
 		}
 	},
 	Run: func(cmd *cobra.Command, args []string) {
-		envQuery := strings.Split(envCheck, ",")
-
 		if noConfig {
 			cmd.Help()
 			os.Exit(0)
 		}
 
 		if viper.IsSet("queries") {
-			queries := viper.Get("queries")
-			fileTargets := map[string]string{}
-			domainTargets := map[string][]string{}
-
-			for env, data := range queries.(map[string]interface{}) {
-				if envCheck != "" && !sliceContains(envQuery, env) {
-					continue
-				}
-				switch data := data.(type) {
-				case string:
-					fileTargets[env] = data
-				case []interface{}:
-					domains := make([]string, len(data))
-					for k, domain := range data {
-						switch domain := domain.(type) {
-						case string:
-							domains[k] = domain
-						default:
-							log.Fatal().Msgf("Unsupported data type in query option for %s: %v is of type %T", env, domain, domain)
-						}
-						domainTargets[env] = domains
-					}
-				default:
-					log.Fatal().Msgf("Unsupported data type in queries option: %v is of type %T", data, data)
-				}
-			}
-			log.Debug().Msgf("fileTargets is  : %v", fileTargets)
-			log.Debug().Msgf("domainTargets is: %v", domainTargets)
-
-			runQueries(fileTargets, domainTargets)
-
+			runQueries(configQueries(envCheck))
 		} else {
 			// Nothing to do
 			log.Debug().Msgf("Empty query... nothing to do")
@@ -119,12 +92,99 @@ var listEnvs = &cobra.Command{
 	},
 }
 
-func runQueries(fileTargets map[string]string, domainTargets map[string][]string) {
+func runQueries(qc *queryConfig) {
+	if _, err := domains.NewReportWriter(viper.GetString("format")); err != nil {
+		log.Fatal().Msgf("Error invalid format option: %v", err)
+	}
+
+	queries, err := domains.LoadQueries(qc.files, qc.domains)
+	if err != nil {
+		log.Fatal().Msgf("Error while loading targets: %v", err)
+	}
+	limits := domains.Limits{
+		Concurrency: viper.GetInt("concurrency"),
+		QPS:         viper.GetFloat64("qps"),
+		PerHost:     viper.GetInt("per_host"),
+	}
+	if limits.QPS < 0 || limits.PerHost < 0 {
+		log.Fatal().Msgf("Error in limits: qps (%v) and per_host (%d) can't be negative", limits.QPS, limits.PerHost)
+	}
+	opts := domains.Options{
+		Timeout: time.Duration(viper.GetInt("timeout")) * time.Second,
+		Retries: viper.GetInt("retries"),
+		Backoff: viper.GetDuration("retry_backoff"),
+
+		AllAddresses: viper.GetBool("all_addresses"),
+		Connect:      viper.GetString("connect_to"),
+		SNI:          viper.GetString("sni"),
+		NoSNI:        viper.GetBool("no_sni"),
+		VerifyName:   viper.GetString("verify_name"),
+		TrustStore:   globalTrust().trustStore("global"),
+		ClientCert:   globalClient().clientCert("global"),
+		OCSP:         viper.GetBool("ocsp"),
+		Scan:         viper.GetBool("scan"),
+		CAA:          viper.GetBool("caa"),
+		DANE:         viper.GetBool("dane"),
+		HTTP:         viper.GetBool("http"),
+		Resolver:     viper.GetString("resolver"),
+		Proxy:        parseProxy("global", viper.GetString("proxy")),
+	}
+	opts.Source, err = domains.SourceAddresses(viper.GetString("source_addr"), viper.GetString("interface"))
+	if err != nil {
+		log.Fatal().Msgf("Error in source address: %v", err)
+	}
+	if viper.GetBool("crl") {
+		opts.CRL, err = domains.NewCRLCache(viper.GetString("crl_cache_dir"), viper.GetBool("offline"), opts.Timeout)
+		if err != nil {
+			log.Fatal().Msgf("Error in CRL cache: %v", err)
+		}
+	}
+	if file := viper.GetString("ct_log_list"); file != "" {
+		opts.CTLogs, err = domains.LoadCTLogList(file)
+		if err != nil {
+			log.Fatal().Msgf("Error in CT log list: %v", err)
+		}
+	}
+	// Environments with their own trust store, client certificate, proxy or
+	// resolver
+	for k, q := range queries {
+		trust, hasTrust := qc.trust[q.Environment]
+		client, hasClient := qc.clients[q.Environment]
+		proxy, hasProxy := qc.proxies[q.Environment]
+		resolver, hasResolver := qc.resolvers[q.Environment]
+		if !hasTrust && !hasClient && !hasProxy && !hasResolver {
+			continue
+		}
+		envOpts := opts
+		if hasTrust {
+			envOpts.TrustStore = trust.trustStore(q.Environment)
+		}
+		if hasClient {
+			envOpts.ClientCert = client.clientCert(q.Environment)
+		}
+		if hasProxy {
+			envOpts.Proxy = proxy
+		}
+		if hasResolver {
+			envOpts.Resolver = resolver
+		}
+		queries[k].Options = &envOpts
+	}
+	runner := domains.NewRunner(queries, opts, limits)
+
+	// SIGINT/SIGTERM and the run deadline cancel all outstanding probes
+	ctx, cancel := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer cancel()
+	if deadline := viper.GetDuration("deadline"); deadline > 0 {
+		ctx, cancel = context.WithTimeout(ctx, deadline)
+		defer cancel()
+	}
+
 	if viper.GetBool("silent") {
-		fmt.Fprintln(os.Stderr, "Processing query!")
+		runCheck(ctx, runner, qc.thresholds)
+		return
 	}
-	q := ui.NewModel(viper.GetInt("timeout"), viper.GetBool("silent"), fileTargets, domainTargets)
-	if err := tea.NewProgram(q).Start(); err != nil {
+	if _, err := tea.NewProgram(ui.NewModel(ctx, runner)).Run(); err != nil {
 		log.Fatal().Msgf("Error while running TUI program: %v", err)
 	}
 }
@@ -144,11 +204,81 @@ func init() {
 	rootCmd.PersistentFlags().BoolP("silent", "s", false, "disable ui")
 	rootCmd.PersistentFlags().BoolP("debug", "d", false, "Enable debug log, out will be saved in "+logFile)
 	rootCmd.PersistentFlags().Uint16P("timeout", "t", 10, "Set timeout for SSL check queries")
+	rootCmd.PersistentFlags().Int("retries", 0, "Number of retries on transient failures (timeouts, resets, temporary DNS errors)")
+	rootCmd.PersistentFlags().Duration("retry-backoff", domains.DefaultBackoff, "Base delay between retries, doubled on each attempt with jitter")
+	rootCmd.PersistentFlags().Bool("all-addresses", false, "Probe every resolved IPv4 and IPv6 address of each hostname")
+	rootCmd.PersistentFlags().String("connect-to", "", "Connect to this address (host or host:port) instead of the target host, e.g. a load balancer VIP")
+	rootCmd.PersistentFlags().String("sni", "", "Server name sent in the handshake instead of the target host")
+	rootCmd.PersistentFlags().Bool("no-sni", false, "Send no server name in the handshake")
+	rootCmd.PersistentFlags().String("verify-name", "", "Name the certificate is verified against instead of the server name")
+	rootCmd.PersistentFlags().String("ca-file", "", "PEM file of the roots certificates are verified against instead of the system ones")
+	rootCmd.PersistentFlags().String("ca-dir", "", "Directory of PEM files of the roots certificates are verified against instead of the system ones")
+	rootCmd.PersistentFlags().Bool("ca-append", false, "Add the --ca-file and --ca-dir roots to the system ones instead of replacing them")
+	rootCmd.PersistentFlags().String("client-cert", "", "PEM client certificate sent to servers requiring mutual TLS")
+	rootCmd.PersistentFlags().String("client-key", "", "PEM private key of --client-cert")
+	rootCmd.PersistentFlags().String("client-p12", "", "PKCS#12 bundle of the client certificate and key, instead of --client-cert and --client-key")
+	rootCmd.PersistentFlags().String("client-p12-password", "", "Password of the --client-p12 bundle")
+	rootCmd.PersistentFlags().Bool("ocsp", false, "Query the OCSP responder of each certificate for its revocation status")
+	rootCmd.PersistentFlags().Bool("crl", false, "Check the chain against the CRLs of its distribution points")
+	rootCmd.PersistentFlags().String("crl-cache-dir", domains.DefaultCRLCacheDir(), "Directory CRLs are cached in until their next update, as <hex sha256 of the URL>.crl")
+	rootCmd.PersistentFlags().Bool("offline", false, "Only use the CRLs already in the cache, even stale ones")
+	rootCmd.PersistentFlags().String("ct-log-list", "", "CT log list (v3 JSON) SCT signatures are verified against, SCTs are only decoded without it")
+	rootCmd.PersistentFlags().Bool("caa", false, "Check that the CAA records of each hostname authorize the certificate issuer")
+	rootCmd.PersistentFlags().Bool("dane", false, "Match the TLSA records of each endpoint against its certificate chain")
+	rootCmd.PersistentFlags().Bool("http", false, "Send a HEAD request over the TLS connection of https targets to check the status, HSTS and redirects")
+	rootCmd.PersistentFlags().String("proxy", "", "HTTP CONNECT or SOCKS5 proxy URL (http://, https://, socks5://, socks5h://, with optional user:password@), HTTPS_PROXY by default, \"direct\" for none")
+	rootCmd.PersistentFlags().String("resolver", "", "DNS server (host or host:port) hostnames, CAA and TLSA records are resolved with, the system resolver by default")
+	rootCmd.PersistentFlags().String("source-addr", "", "Local IP address probes and DNS queries are sent from")
+	rootCmd.PersistentFlags().String("interface", "", "Network interface whose addresses probes and DNS queries are sent from, instead of --source-addr")
+	rootCmd.PersistentFlags().Bool("scan", false, "Enumerate the TLS versions and cipher suites accepted by each endpoint, tens of handshakes each counting against --qps")
+	rootCmd.PersistentFlags().Duration("deadline", 0, "Overall deadline for the whole run (e.g. 5m), unfinished targets are reported as cancelled")
+	rootCmd.PersistentFlags().Int("concurrency", domains.DefaultConcurrency, "Maximum number of probes running at once")
+	rootCmd.PersistentFlags().Float64("qps", 0, "Maximum number of probes started per second, 0 for no limit")
+	rootCmd.PersistentFlags().Int("per-host", 0, "Maximum number of concurrent probes to a single hostname and connections to a single IP, 0 for no limit")
+	rootCmd.PersistentFlags().String("warn", "30d", "Remaining validity under which a certificate is a warning (silent mode exit code)")
+	rootCmd.PersistentFlags().String("crit", "7d", "Remaining validity under which a certificate is critical (silent mode exit code)")
+	rootCmd.PersistentFlags().StringP("format", "f", domains.DefaultReportFormat, "Report format in silent mode: "+strings.Join(domains.ReportFormats(), ", "))
+	rootCmd.PersistentFlags().StringP("output", "o", "", "Write the silent mode report to this file instead of stdout")
 	rootCmd.Flags().StringVarP(&envCheck, "environments", "e", "", "Comma delimited string specifying the environments to check")
 
 	viper.BindPFlag("silent", rootCmd.PersistentFlags().Lookup("silent"))
 	viper.BindPFlag("debug", rootCmd.PersistentFlags().Lookup("debug"))
 	viper.BindPFlag("timeout", rootCmd.PersistentFlags().Lookup("timeout"))
+	viper.BindPFlag("format", rootCmd.PersistentFlags().Lookup("format"))
+	viper.BindPFlag("output", rootCmd.PersistentFlags().Lookup("output"))
+	viper.BindPFlag("retries", rootCmd.PersistentFlags().Lookup("retries"))
+	viper.BindPFlag("retry_backoff", rootCmd.PersistentFlags().Lookup("retry-backoff"))
+	viper.BindPFlag("all_addresses", rootCmd.PersistentFlags().Lookup("all-addresses"))
+	viper.BindPFlag("connect_to", rootCmd.PersistentFlags().Lookup("connect-to"))
+	viper.BindPFlag("sni", rootCmd.PersistentFlags().Lookup("sni"))
+	viper.BindPFlag("no_sni", rootCmd.PersistentFlags().Lookup("no-sni"))
+	viper.BindPFlag("verify_name", rootCmd.PersistentFlags().Lookup("verify-name"))
+	viper.BindPFlag("ca_file", rootCmd.PersistentFlags().Lookup("ca-file"))
+	viper.BindPFlag("ca_dir", rootCmd.PersistentFlags().Lookup("ca-dir"))
+	viper.BindPFlag("ca_append", rootCmd.PersistentFlags().Lookup("ca-append"))
+	viper.BindPFlag("client_cert", rootCmd.PersistentFlags().Lookup("client-cert"))
+	viper.BindPFlag("client_key", rootCmd.PersistentFlags().Lookup("client-key"))
+	viper.BindPFlag("client_p12", rootCmd.PersistentFlags().Lookup("client-p12"))
+	viper.BindPFlag("client_p12_password", rootCmd.PersistentFlags().Lookup("client-p12-password"))
+	viper.BindPFlag("ocsp", rootCmd.PersistentFlags().Lookup("ocsp"))
+	viper.BindPFlag("crl", rootCmd.PersistentFlags().Lookup("crl"))
+	viper.BindPFlag("crl_cache_dir", rootCmd.PersistentFlags().Lookup("crl-cache-dir"))
+	viper.BindPFlag("offline", rootCmd.PersistentFlags().Lookup("offline"))
+	viper.BindPFlag("ct_log_list", rootCmd.PersistentFlags().Lookup("ct-log-list"))
+	viper.BindPFlag("caa", rootCmd.PersistentFlags().Lookup("caa"))
+	viper.BindPFlag("dane", rootCmd.PersistentFlags().Lookup("dane"))
+	viper.BindPFlag("http", rootCmd.PersistentFlags().Lookup("http"))
+	viper.BindPFlag("proxy", rootCmd.PersistentFlags().Lookup("proxy"))
+	viper.BindPFlag("resolver", rootCmd.PersistentFlags().Lookup("resolver"))
+	viper.BindPFlag("source_addr", rootCmd.PersistentFlags().Lookup("source-addr"))
+	viper.BindPFlag("interface", rootCmd.PersistentFlags().Lookup("interface"))
+	viper.BindPFlag("scan", rootCmd.PersistentFlags().Lookup("scan"))
+	viper.BindPFlag("deadline", rootCmd.PersistentFlags().Lookup("deadline"))
+	viper.BindPFlag("concurrency", rootCmd.PersistentFlags().Lookup("concurrency"))
+	viper.BindPFlag("qps", rootCmd.PersistentFlags().Lookup("qps"))
+	viper.BindPFlag("per_host", rootCmd.PersistentFlags().Lookup("per-host"))
+	viper.BindPFlag("warn", rootCmd.PersistentFlags().Lookup("warn"))
+	viper.BindPFlag("crit", rootCmd.PersistentFlags().Lookup("crit"))
 
 	rootCmd.AddCommand(listEnvs)
 }
@@ -13,11 +13,11 @@ var fileTargetsCmd = &cobra.Command{
 	Args:  cobra.ExactArgs(1),
 	Run: func(cmd *cobra.Command, args []string) {
 		targetsList := strings.Split(args[0], ",")
-		targets := make(map[string]string, len(targetsList))
+		qc := newQueryConfig()
 		for _, e := range targetsList {
-			targets[filepath.Base(e)] = e
+			qc.files[filepath.Base(e)] = e
 		}
-		runQueries(targets, map[string][]string{})
+		runQueries(qc)
 	},
 }
 
@@ -28,10 +28,10 @@ var domainTargetsCmd = &cobra.Command{
 	Run: func(cmd *cobra.Command, args []string) {
 		targetsList := strings.Split(args[0], ",")
 
-		targets := make(map[string][]string, 1)
-		targets["customDomains"] = targetsList
+		qc := newQueryConfig()
+		qc.domains["customDomains"] = targetsList
 
-		runQueries(map[string]string{}, targets)
+		runQueries(qc)
 	},
 }
 
@@ -0,0 +1,218 @@
+package domains
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// typeCAA is the CAA resource record type, unknown to dnsmessage
+const typeCAA dnsmessage.Type = 257
+
+// CAA property tags (RFC 8659)
+const (
+	CAAIssue     = "issue"
+	CAAIssueWild = "issuewild"
+	CAAIodef     = "iodef"
+)
+
+// CAA audit outcomes of the certificate issuer
+const (
+	CAAStatusAuthorized   = "authorized"
+	CAAStatusUnauthorized = "unauthorized"
+	// CAAStatusUnknown is set when the issuer has no known CAA identifier,
+	// or the records could not be read
+	CAAStatusUnknown = "unknown"
+)
+
+// CAARecord is a CAA resource record
+type CAARecord struct {
+	Critical bool
+	Tag      string
+	Value    string
+}
+
+// CAAResult is the relevant CAA record set of a hostname, the first one
+// found walking up from the hostname to the TLD
+type CAAResult struct {
+	// Domain is where the records were found, empty when none exist
+	Domain    string
+	Issue     []string
+	IssueWild []string
+	Iodef     []string
+	// Records holds every record of the set, including unknown tags
+	Records []CAARecord
+	Error   error
+}
+
+// caaIdentifiers maps issuer organizations to the CAA identifiers of their
+// CA, other issuers can't be audited
+var caaIdentifiers = map[string][]string{
+	"Let's Encrypt":                {"letsencrypt.org"},
+	"Google Trust Services":        {"pki.goog"},
+	"Google Trust Services LLC":    {"pki.goog"},
+	"Amazon":                       {"amazon.com", "amazontrust.com", "awstrust.com", "amazonaws.com"},
+	"DigiCert Inc":                 {"digicert.com", "symantec.com", "geotrust.com", "rapidssl.com", "thawte.com", "digicert.ne.jp"},
+	"Sectigo Limited":              {"sectigo.com", "comodoca.com", "comodo.com", "usertrust.com", "trust-provider.com"},
+	"ZeroSSL":                      {"sectigo.com", "zerossl.com"},
+	"GlobalSign nv-sa":             {"globalsign.com"},
+	"Entrust, Inc.":                {"entrust.net", "affirmtrust.com"},
+	"GoDaddy.com, Inc.":            {"godaddy.com", "starfieldtech.com"},
+	"Starfield Technologies, Inc.": {"starfieldtech.com", "godaddy.com"},
+	"Microsoft Corporation":        {"microsoft.com", "digicert.com"},
+	"SSL Corporation":              {"ssl.com"},
+	"Buypass AS-983163327":         {"buypass.com", "buypass.no"},
+	"Asseco Data Systems S.A.":     {"certum.pl", "certum.eu"},
+	"Actalis S.p.A.":               {"actalis.it"},
+}
+
+// lookupCAA walks the CAA tree of host (RFC 8659 section 3), from the
+// hostname to its parents, and returns the first non-empty record set
+func lookupCAA(ctx context.Context, resolver string, source []net.IP, host string, timeout time.Duration) *CAAResult {
+	server, err := resolverAddress(resolver)
+	if err != nil {
+		return &CAAResult{Error: err}
+	}
+	name := strings.TrimSuffix(host, ".")
+	for strings.Contains(name, ".") {
+		answers, err := queryDNS(ctx, server, source, name, typeCAA, timeout)
+		if err != nil && !errors.Is(err, errNoSuchDomain) {
+			return &CAAResult{Domain: name, Error: fmt.Errorf("CAA lookup failed: %w", err)}
+		}
+		result := &CAAResult{}
+		for _, a := range answers {
+			// Answers may start with the CNAME chain of name
+			body, ok := a.Body.(*dnsmessage.UnknownResource)
+			if !ok || a.Header.Type != typeCAA {
+				continue
+			}
+			r, err := parseCAA(body.Data)
+			if err != nil {
+				return &CAAResult{Domain: name, Error: err}
+			}
+			result.add(r)
+		}
+		if len(result.Records) > 0 {
+			result.Domain = name
+			return result
+		}
+		name = name[strings.Index(name, ".")+1:]
+	}
+	return &CAAResult{}
+}
+
+// parseCAA decodes the RDATA of a CAA record
+func parseCAA(data []byte) (CAARecord, error) {
+	if len(data) < 2 || len(data) < 2+int(data[1]) {
+		return CAARecord{}, errors.New("invalid CAA record")
+	}
+	tagLen := int(data[1])
+	return CAARecord{
+		Critical: data[0]&0x80 != 0,
+		Tag:      strings.ToLower(string(data[2 : 2+tagLen])),
+		Value:    string(data[2+tagLen:]),
+	}, nil
+}
+
+func (c *CAAResult) add(r CAARecord) {
+	c.Records = append(c.Records, r)
+	switch r.Tag {
+	case CAAIssue:
+		c.Issue = append(c.Issue, r.Value)
+	case CAAIssueWild:
+		c.IssueWild = append(c.IssueWild, r.Value)
+	case CAAIodef:
+		c.Iodef = append(c.Iodef, r.Value)
+	}
+}
+
+// Authorizes tells whether the CA of the organization issuer may issue the
+// certificate, wildcard selecting the issuewild set when present. Without
+// records any CA is authorized, and an issuer without known CAA identifier
+// is unknown unless the records forbid every CA.
+func (c *CAAResult) Authorizes(issuer string, wildcard bool) string {
+	switch {
+	case c == nil || c.Error != nil:
+		return CAAStatusUnknown
+	case len(c.Records) == 0:
+		return CAAStatusAuthorized
+	case c.criticalUnknown():
+		return CAAStatusUnauthorized
+	}
+	values := c.Issue
+	if wildcard && len(c.IssueWild) > 0 {
+		values = c.IssueWild
+	}
+	if len(values) == 0 {
+		// Only iodef or unknown tags, issuance is not restricted
+		return CAAStatusAuthorized
+	}
+	ids, known := caaIdentifiers[issuer]
+	restricted := true
+	for _, v := range values {
+		// Parameters follow the issuer domain, an empty domain forbids issuance
+		domain := strings.ToLower(strings.TrimSuffix(strings.TrimSpace(strings.SplitN(v, ";", 2)[0]), "."))
+		if domain == "" {
+			continue
+		}
+		restricted = false
+		for _, id := range ids {
+			if id == domain {
+				return CAAStatusAuthorized
+			}
+		}
+	}
+	if known || restricted {
+		return CAAStatusUnauthorized
+	}
+	return CAAStatusUnknown
+}
+
+// criticalUnknown tells whether a record with an unknown tag is flagged
+// critical, which forbids issuance
+func (c *CAAResult) criticalUnknown() bool {
+	for _, r := range c.Records {
+		if r.Critical && r.Tag != CAAIssue && r.Tag != CAAIssueWild && r.Tag != CAAIodef {
+			return true
+		}
+	}
+	return false
+}
+
+// CAAStatus tells whether the CAA records of the domain authorize the
+// issuer of the leaf, empty when CAA was not looked up
+func (i Response) CAAStatus() string {
+	if i.CAA == nil || len(i.Chain) == 0 {
+		return ""
+	}
+	return i.CAA.Authorizes(caaIssuer(i), caaWildcard(i))
+}
+
+// caaIssuer returns the organization the issuing CA is known by
+func caaIssuer(i Response) string {
+	if len(i.Issuer.Organization) > 0 {
+		return i.Issuer.Organization[0]
+	}
+	return i.Issuer.CommonName
+}
+
+// caaWildcard tells whether the leaf covers the domain through a wildcard
+// name only
+func caaWildcard(i Response) bool {
+	for _, san := range i.SAN {
+		if strings.EqualFold(san, i.Domain) {
+			return false
+		}
+	}
+	for _, san := range i.SAN {
+		if strings.HasPrefix(san, "*.") {
+			return true
+		}
+	}
+	return false
+}
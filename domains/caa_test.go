@@ -0,0 +1,137 @@
+package domains
+
+import (
+	"context"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+func TestCAAAuthorizes(t *testing.T) {
+	records := func(rs ...CAARecord) *CAAResult {
+		c := &CAAResult{Domain: "example.test"}
+		for _, r := range rs {
+			c.add(r)
+		}
+		return c
+	}
+	issue := func(value string) CAARecord { return CAARecord{Tag: CAAIssue, Value: value} }
+	issueWild := func(value string) CAARecord { return CAARecord{Tag: CAAIssueWild, Value: value} }
+
+	tests := []struct {
+		name     string
+		caa      *CAAResult
+		issuer   string
+		wildcard bool
+		want     string
+	}{
+		{"no records", records(), "Let's Encrypt", false, CAAStatusAuthorized},
+		{"known identifier", records(issue("letsencrypt.org")), "Let's Encrypt", false, CAAStatusAuthorized},
+		{"identifier with parameters", records(issue("LetsEncrypt.org.; validationmethods=dns-01")), "Let's Encrypt", false, CAAStatusAuthorized},
+		{"other CA", records(issue("pki.goog")), "Let's Encrypt", false, CAAStatusUnauthorized},
+		// The first label of ca.example is part of most CA names
+		{"unknown issuer", records(issue("ca.example")), "Example CA Corp", false, CAAStatusUnknown},
+		{"issuance forbidden", records(issue(";")), "Example CA Corp", false, CAAStatusUnauthorized},
+		{"iodef only", records(CAARecord{Tag: CAAIodef, Value: "mailto:security@example.test"}), "Let's Encrypt", false, CAAStatusAuthorized},
+		{"issuewild for wildcards", records(issue("pki.goog"), issueWild("letsencrypt.org")), "Let's Encrypt", true, CAAStatusAuthorized},
+		{"issue for names", records(issue("pki.goog"), issueWild("letsencrypt.org")), "Let's Encrypt", false, CAAStatusUnauthorized},
+		{"issue for wildcards without issuewild", records(issue("letsencrypt.org")), "Let's Encrypt", true, CAAStatusAuthorized},
+		{"critical unknown tag", records(issue("letsencrypt.org"), CAARecord{Critical: true, Tag: "future", Value: "x"}), "Let's Encrypt", false, CAAStatusUnauthorized},
+		{"non critical unknown tag", records(issue("letsencrypt.org"), CAARecord{Tag: "future", Value: "x"}), "Let's Encrypt", false, CAAStatusAuthorized},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.caa.Authorizes(tt.issuer, tt.wildcard); got != tt.want {
+				t.Errorf("got %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+// caaRR returns a CAA record for the DNS stub
+func caaRR(critical bool, tag, value string) dnsmessage.ResourceBody {
+	var flags byte
+	if critical {
+		flags = 0x80
+	}
+	data := append([]byte{flags, byte(len(tag))}, tag...)
+	return &dnsmessage.UnknownResource{Type: typeCAA, Data: append(data, value...)}
+}
+
+func TestLookupCAA(t *testing.T) {
+	server := newDNSStub(t, dnsZone{
+		"www.sub.example.test.": {dnsmessage.TypeA: {&dnsmessage.AResource{A: [4]byte{192, 0, 2, 1}}}},
+		"example.test.": {typeCAA: {
+			caaRR(false, CAAIssue, "letsencrypt.org"),
+			caaRR(false, CAAIssueWild, "pki.goog"),
+			caaRR(false, CAAIodef, "mailto:security@example.test"),
+		}},
+		"alias.example.test.": {dnsmessage.TypeCNAME: {&dnsmessage.CNAMEResource{CNAME: dnsmessage.MustNewName("cdn.provider.test.")}}},
+		"cdn.provider.test.":  {typeCAA: {caaRR(false, CAAIssue, "pki.goog")}},
+		"crit.example.test.": {typeCAA: {
+			caaRR(false, CAAIssue, "letsencrypt.org"),
+			caaRR(true, "future", "x"),
+		}},
+		"none.test.": {},
+	})
+	lookup := func(host string) *CAAResult {
+		return lookupCAA(context.Background(), server, nil, host, time.Second)
+	}
+
+	// www.sub has no records and sub does not exist, example.test is used
+	r := lookup("www.sub.example.test")
+	if r.Error != nil || r.Domain != "example.test" {
+		t.Fatalf("got %+v, want the records of example.test", r)
+	}
+	if !reflect.DeepEqual(r.Issue, []string{"letsencrypt.org"}) || !reflect.DeepEqual(r.IssueWild, []string{"pki.goog"}) || len(r.Iodef) != 1 {
+		t.Errorf("got issue %v issuewild %v iodef %v", r.Issue, r.IssueWild, r.Iodef)
+	}
+	if got := r.Authorizes("Let's Encrypt", false); got != CAAStatusAuthorized {
+		t.Errorf("Let's Encrypt for a name: got %s", got)
+	}
+	if got := r.Authorizes("Let's Encrypt", true); got != CAAStatusUnauthorized {
+		t.Errorf("Let's Encrypt for a wildcard: got %s", got)
+	}
+
+	// The records of the CNAME target apply to the alias
+	if r := lookup("alias.example.test"); r.Domain != "alias.example.test" || !reflect.DeepEqual(r.Issue, []string{"pki.goog"}) {
+		t.Errorf("got %+v for the alias, want the records of its target", r)
+	}
+
+	r = lookup("crit.example.test")
+	if len(r.Records) != 2 || !r.Records[1].Critical || r.Records[1].Tag != "future" {
+		t.Fatalf("got records %+v", r.Records)
+	}
+	if got := r.Authorizes("Let's Encrypt", false); got != CAAStatusUnauthorized {
+		t.Errorf("critical unknown tag: got %s, want %s", got, CAAStatusUnauthorized)
+	}
+
+	if r := lookup("www.none.test"); r.Error != nil || r.Domain != "" || r.Authorizes("Let's Encrypt", false) != CAAStatusAuthorized {
+		t.Errorf("got %+v without records, want any CA authorized", r)
+	}
+}
+
+func TestProbeCAAFinding(t *testing.T) {
+	ca := newTestCA(t, "Let's Encrypt")
+	leaf := newTestLeaf(t, ca, "www.example.test")
+	resolver := newDNSStub(t, dnsZone{
+		"example.test.": {typeCAA: {caaRR(false, CAAIssue, "pki.goog")}},
+	})
+	addr, errc := serveTLS(t, nil, leaf.tlsCertificate(ca))
+
+	resp := probe(t, "www.example.test?connect="+addr, Options{CAA: true, Resolver: resolver, TrustStore: testTrustStore(ca)})
+	<-errc
+	if resp.CAAStatus() != CAAStatusUnauthorized {
+		t.Fatalf("got CAA status %q (%+v), want %s", resp.CAAStatus(), resp.CAA, CAAStatusUnauthorized)
+	}
+	var found bool
+	for _, f := range resp.Findings {
+		found = found || strings.Contains(f.Message, "not authorized by the CAA records of example.test")
+	}
+	if !found {
+		t.Errorf("got findings %v, want the CAA one", resp.Findings)
+	}
+}
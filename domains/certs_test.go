@@ -0,0 +1,93 @@
+package domains
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"testing"
+	"time"
+)
+
+// testCert is a certificate generated for the tests along with its key
+type testCert struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+}
+
+// newTestCert signs tmpl with issuer, self-signed when issuer is nil
+func newTestCert(t *testing.T, tmpl *x509.Certificate, issuer *testCert) *testCert {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if tmpl.SerialNumber == nil {
+		serial, err := rand.Int(rand.Reader, big.NewInt(1<<62))
+		if err != nil {
+			t.Fatal(err)
+		}
+		tmpl.SerialNumber = serial
+	}
+	if tmpl.NotBefore.IsZero() {
+		tmpl.NotBefore = time.Now().Add(-time.Hour)
+		tmpl.NotAfter = time.Now().Add(90 * 24 * time.Hour)
+	}
+	parent, signer := tmpl, key
+	if issuer != nil {
+		parent, signer = issuer.cert, issuer.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, parent, &key.PublicKey, signer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testCert{cert: cert, key: key}
+}
+
+// newTestCA returns a self-signed root
+func newTestCA(t *testing.T, name string) *testCert {
+	t.Helper()
+	return newTestCert(t, &x509.Certificate{
+		Subject:               pkix.Name{CommonName: name, Organization: []string{name}},
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+	}, nil)
+}
+
+// newTestLeaf returns a server certificate for names issued by ca
+func newTestLeaf(t *testing.T, ca *testCert, names ...string) *testCert {
+	t.Helper()
+	return newTestCert(t, &x509.Certificate{
+		Subject:     pkix.Name{CommonName: names[0]},
+		DNSNames:    names,
+		KeyUsage:    x509.KeyUsageDigitalSignature,
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}, ca)
+}
+
+// tlsCertificate returns c with the rest of its chain for a tls.Config
+func (c *testCert) tlsCertificate(chain ...*testCert) tls.Certificate {
+	cert := tls.Certificate{Certificate: [][]byte{c.cert.Raw}, PrivateKey: c.key, Leaf: c.cert}
+	for _, issuer := range chain {
+		cert.Certificate = append(cert.Certificate, issuer.cert.Raw)
+	}
+	return cert
+}
+
+// testTrustStore returns a trust store holding the roots only
+func testTrustStore(roots ...*testCert) *TrustStore {
+	store := &TrustStore{Name: "test", Pool: x509.NewCertPool()}
+	for _, r := range roots {
+		store.Pool.AddCert(r.cert)
+		store.certs = append(store.certs, r.cert)
+	}
+	return store
+}
@@ -0,0 +1,68 @@
+package domains
+
+import (
+	"crypto/tls"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"fmt"
+	"os"
+
+	"software.sslmate.com/src/go-pkcs12"
+)
+
+// LoadClientCertificate reads a PEM certificate and key pair used to
+// authenticate to servers requiring mutual TLS
+func LoadClientCertificate(certFile, keyFile string) (*tls.Certificate, error) {
+	cert, err := tls.LoadX509KeyPair(os.ExpandEnv(certFile), os.ExpandEnv(keyFile))
+	if err != nil {
+		return nil, fmt.Errorf("can't load client certificate: %w", err)
+	}
+	return &cert, nil
+}
+
+// LoadClientPKCS12 reads a client certificate, its key and chain from a
+// PKCS#12 bundle
+func LoadClientPKCS12(file, password string) (*tls.Certificate, error) {
+	data, err := os.ReadFile(os.ExpandEnv(file))
+	if err != nil {
+		return nil, fmt.Errorf("can't load client certificate: %w", err)
+	}
+	key, leaf, chain, err := pkcs12.DecodeChain(data, password)
+	if err != nil {
+		return nil, fmt.Errorf("can't decode client certificate %s: %w", file, err)
+	}
+	cert := &tls.Certificate{PrivateKey: key, Leaf: leaf}
+	cert.Certificate = append(cert.Certificate, leaf.Raw)
+	for _, c := range chain {
+		cert.Certificate = append(cert.Certificate, c.Raw)
+	}
+	return cert, nil
+}
+
+// clientAuth records the client certificate request of a handshake
+type clientAuth struct {
+	requested     bool
+	acceptableCAs []string
+}
+
+// getClientCertificate returns the GetClientCertificate callback of a
+// handshake, it records the request and answers with cert, or with no
+// certificate when none is configured
+func (a *clientAuth) getClientCertificate(cert *tls.Certificate) func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+	return func(info *tls.CertificateRequestInfo) (*tls.Certificate, error) {
+		a.requested = true
+		for _, raw := range info.AcceptableCAs {
+			var rdn pkix.RDNSequence
+			if _, err := asn1.Unmarshal(raw, &rdn); err != nil {
+				continue
+			}
+			var name pkix.Name
+			name.FillFromRDNSequence(&rdn)
+			a.acceptableCAs = append(a.acceptableCAs, name.String())
+		}
+		if cert == nil {
+			return &tls.Certificate{}, nil
+		}
+		return cert, nil
+	}
+}
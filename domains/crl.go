@@ -0,0 +1,214 @@
+package domains
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// maxCRLSize bounds the size of downloaded CRLs
+const maxCRLSize = 64 << 20
+
+// DefaultCRLCacheDir returns the directory CRLs are cached in by default
+func DefaultCRLCacheDir() string {
+	dir, err := os.UserCacheDir()
+	if err != nil {
+		dir = os.TempDir()
+	}
+	return filepath.Join(dir, "ssl-checker", "crl")
+}
+
+// CRLResult is the revocation status of a certificate of the chain from
+// one of its CRL distribution points
+type CRLResult struct {
+	// Subject is the certificate checked
+	Subject string
+	URL     string
+	// Status is good or revoked, empty when the CRL is unusable
+	Status     string
+	ThisUpdate time.Time
+	NextUpdate time.Time
+	RevokedAt  time.Time
+	// Stale is set when the CRL is past its nextUpdate, only possible offline
+	// or when the distribution point serves an outdated list
+	Stale bool
+	Error error
+}
+
+// CRLCache downloads CRLs and keeps them on disk, keyed by URL, until their
+// nextUpdate. Offline caches only read what is already on disk.
+type CRLCache struct {
+	Dir     string
+	Offline bool
+	Timeout time.Duration
+
+	mu    sync.Mutex
+	lists map[string]*crlEntry
+}
+
+// crlEntry serializes the fetches of a single URL, only successful ones
+// are kept so a transient failure is retried by the next certificate
+type crlEntry struct {
+	mu   sync.Mutex
+	list *x509.RevocationList
+}
+
+// NewCRLCache returns a cache storing CRLs in dir, created when missing
+func NewCRLCache(dir string, offline bool, timeout time.Duration) (*CRLCache, error) {
+	dir = os.ExpandEnv(dir)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, fmt.Errorf("can't create CRL cache: %w", err)
+	}
+	return &CRLCache{Dir: dir, Offline: offline, Timeout: timeout, lists: map[string]*crlEntry{}}, nil
+}
+
+// Check looks up every certificate of chain but the root in the CRLs of its
+// distribution points, downloading them with client. chain must be in
+// issuing order.
+func (c *CRLCache) Check(ctx context.Context, client *http.Client, chain []*x509.Certificate) []CRLResult {
+	var results []CRLResult
+	for k := 0; k+1 < len(chain); k++ {
+		cert, issuer := chain[k], chain[k+1]
+		for _, url := range cert.CRLDistributionPoints {
+			results = append(results, c.check(ctx, client, cert, issuer, url))
+		}
+	}
+	return results
+}
+
+func (c *CRLCache) check(ctx context.Context, client *http.Client, cert, issuer *x509.Certificate, url string) CRLResult {
+	r := CRLResult{Subject: cert.Subject.String(), URL: url}
+	list, err := c.get(ctx, client, url)
+	if err != nil {
+		r.Error = err
+		return r
+	}
+	if err := list.CheckSignatureFrom(issuer); err != nil {
+		r.Error = fmt.Errorf("CRL not signed by the certificate issuer: %w", err)
+		return r
+	}
+	r.ThisUpdate = list.ThisUpdate
+	r.NextUpdate = list.NextUpdate
+	r.Stale = !list.NextUpdate.IsZero() && time.Now().After(list.NextUpdate)
+	r.Status = RevocationGood
+	for _, e := range list.RevokedCertificateEntries {
+		if e.SerialNumber.Cmp(cert.SerialNumber) == 0 {
+			r.Status = RevocationRevoked
+			r.RevokedAt = e.RevocationTime
+			break
+		}
+	}
+	return r
+}
+
+// get returns the CRL of url, from memory, from disk while it is fresh or
+// offline, or downloaded otherwise
+func (c *CRLCache) get(ctx context.Context, client *http.Client, url string) (*x509.RevocationList, error) {
+	c.mu.Lock()
+	entry, ok := c.lists[url]
+	if !ok {
+		entry = &crlEntry{}
+		c.lists[url] = entry
+	}
+	c.mu.Unlock()
+
+	entry.mu.Lock()
+	defer entry.mu.Unlock()
+	if entry.list != nil {
+		return entry.list, nil
+	}
+
+	file := filepath.Join(c.Dir, c.key(url))
+	cached, err := readCRL(file)
+	switch {
+	case err == nil && (c.Offline || cached.NextUpdate.IsZero() || time.Now().Before(cached.NextUpdate)):
+		entry.list = cached
+	case c.Offline:
+		return nil, fmt.Errorf("CRL not in cache for offline run: %w", err)
+	default:
+		list, err := c.download(ctx, client, url, file)
+		switch {
+		case err == nil:
+			entry.list = list
+		case cached != nil:
+			// Keep the outdated copy when the distribution point is unreachable
+			return cached, nil
+		default:
+			return nil, err
+		}
+	}
+	return entry.list, nil
+}
+
+// key returns the cache file name of url, the hex SHA-256 of the URL with
+// a .crl extension, so a cache can be filled for offline runs
+func (c *CRLCache) key(url string) string {
+	sum := sha256.Sum256([]byte(url))
+	return hex.EncodeToString(sum[:]) + ".crl"
+}
+
+func (c *CRLCache) download(ctx context.Context, client *http.Client, url, file string) (*x509.RevocationList, error) {
+	ctx, cancel := context.WithTimeout(ctx, c.Timeout)
+	defer cancel()
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("CRL distribution point returned %s", resp.Status)
+	}
+	data, err := io.ReadAll(io.LimitReader(resp.Body, maxCRLSize))
+	if err != nil {
+		return nil, err
+	}
+	list, err := parseCRL(data)
+	if err != nil {
+		return nil, err
+	}
+	// A failed write only costs a download on the next run, and a warning
+	// on stderr would garble the TUI
+	if err := os.WriteFile(file, data, 0o644); err != nil {
+		log.Debug().Msgf("Can't cache CRL %s: %v", url, err)
+	}
+	return list, nil
+}
+
+func readCRL(file string) (*x509.RevocationList, error) {
+	data, err := os.ReadFile(file)
+	if err != nil {
+		return nil, err
+	}
+	return parseCRL(data)
+}
+
+// parseCRL reads a DER or PEM encoded CRL
+func parseCRL(data []byte) (*x509.RevocationList, error) {
+	if block, _ := pem.Decode(data); block != nil {
+		if block.Type != "X509 CRL" {
+			return nil, errors.New("invalid CRL: unexpected PEM block " + block.Type)
+		}
+		data = block.Bytes
+	}
+	list, err := x509.ParseRevocationList(data)
+	if err != nil {
+		return nil, fmt.Errorf("invalid CRL: %w", err)
+	}
+	return list, nil
+}
@@ -0,0 +1,121 @@
+package domains
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestCRLCacheRetriesFailedDownloads(t *testing.T) {
+	ca := newTestCA(t, "Test CA")
+	var requests atomic.Int32
+	var crl []byte
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// The first download fails, like a distribution point hiccup
+		if requests.Add(1) == 1 {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		w.Write(crl)
+	}))
+	defer srv.Close()
+
+	newLeaf := func() *testCert {
+		return newTestCert(t, &x509.Certificate{
+			DNSNames:              []string{"crl.example.test"},
+			CRLDistributionPoints: []string{srv.URL + "/ca.crl"},
+		}, ca)
+	}
+	good, revoked := newLeaf(), newLeaf()
+	var err error
+	crl, err = x509.CreateRevocationList(rand.Reader, &x509.RevocationList{
+		Number:     big.NewInt(1),
+		ThisUpdate: time.Now().Add(-time.Hour),
+		NextUpdate: time.Now().Add(24 * time.Hour),
+		RevokedCertificateEntries: []x509.RevocationListEntry{
+			{SerialNumber: revoked.cert.SerialNumber, RevocationTime: time.Now().Add(-time.Hour)},
+		},
+	}, ca.cert, ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cache, err := NewCRLCache(t.TempDir(), false, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	if r := cache.Check(ctx, http.DefaultClient, []*x509.Certificate{good.cert, ca.cert}); len(r) != 1 || r[0].Error == nil {
+		t.Fatalf("got %+v, want the download error", r)
+	}
+	if r := cache.Check(ctx, http.DefaultClient, []*x509.Certificate{good.cert, ca.cert}); len(r) != 1 || r[0].Status != RevocationGood {
+		t.Fatalf("got %+v after the failure, want good", r)
+	}
+	if r := cache.Check(ctx, http.DefaultClient, []*x509.Certificate{revoked.cert, ca.cert}); len(r) != 1 || r[0].Status != RevocationRevoked {
+		t.Fatalf("got %+v, want revoked", r)
+	}
+	if n := requests.Load(); n != 2 {
+		t.Errorf("got %d downloads, want 2", n)
+	}
+
+	// Offline runs read the copy written by the download
+	offline, err := NewCRLCache(cache.Dir, true, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r := offline.Check(ctx, http.DefaultClient, []*x509.Certificate{revoked.cert, ca.cert}); len(r) != 1 || r[0].Status != RevocationRevoked {
+		t.Errorf("got %+v offline, want revoked", r)
+	}
+}
+
+// TestCRLCacheOfflineStale checks an offline run reads a cache filled by
+// hand under the documented names, flagging a CRL past its nextUpdate
+func TestCRLCacheOfflineStale(t *testing.T) {
+	ca := newTestCA(t, "Test CA")
+	url := "http://crl.example.test/ca.crl"
+	leaf := newTestCert(t, &x509.Certificate{DNSNames: []string{"crl.example.test"}, CRLDistributionPoints: []string{url}}, ca)
+	der, err := x509.CreateRevocationList(rand.Reader, &x509.RevocationList{
+		Number:     big.NewInt(1),
+		ThisUpdate: time.Now().Add(-48 * time.Hour),
+		NextUpdate: time.Now().Add(-24 * time.Hour),
+	}, ca.cert, ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir := t.TempDir()
+	sum := sha256.Sum256([]byte(url))
+	file := filepath.Join(dir, hex.EncodeToString(sum[:])+".crl")
+	if err := os.WriteFile(file, pem.EncodeToMemory(&pem.Block{Type: "X509 CRL", Bytes: der}), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	cache, err := NewCRLCache(dir, true, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := cache.Check(context.Background(), http.DefaultClient, []*x509.Certificate{leaf.cert, ca.cert})
+	if len(r) != 1 || r[0].Error != nil || r[0].Status != RevocationGood || !r[0].Stale {
+		t.Fatalf("got %+v, want good and stale", r)
+	}
+
+	// Without the file an offline run fails rather than downloading
+	os.Remove(file)
+	cache, err = NewCRLCache(dir, true, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r := cache.Check(context.Background(), http.DefaultClient, []*x509.Certificate{leaf.cert, ca.cert}); len(r) != 1 || r[0].Error == nil {
+		t.Errorf("got %+v, want a missing cache error", r)
+	}
+}
@@ -0,0 +1,169 @@
+package domains
+
+import (
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// typeTLSA is the TLSA resource record type, unknown to dnsmessage
+const typeTLSA dnsmessage.Type = 52
+
+// TLSA certificate usages (RFC 6698)
+const (
+	TLSAUsagePKIXTA = 0
+	TLSAUsagePKIXEE = 1
+	TLSAUsageDANETA = 2
+	TLSAUsageDANEEE = 3
+)
+
+// TLSARecord is a TLSA record and whether it matches the presented chain
+type TLSARecord struct {
+	Usage        uint8
+	Selector     uint8
+	MatchingType uint8
+	// Data is the hex encoded certificate association data
+	Data    string
+	Matched bool
+	// Error is why the record can't be used, unknown parameters mostly
+	Error error
+}
+
+// DANEResult holds the TLSA records of an endpoint. The DNSSEC validation
+// of the answer is left to the resolver.
+type DANEResult struct {
+	// Name is the TLSA owner name, _port._tcp.host
+	Name    string
+	Records []TLSARecord
+	Error   error
+}
+
+func (r TLSARecord) String() string {
+	return fmt.Sprintf("%d %d %d %s", r.Usage, r.Selector, r.MatchingType, r.Data)
+}
+
+// DANEMatched tells whether a TLSA record matches the presented chain, true
+// when there are no records or they were not looked up
+func (i Response) DANEMatched() bool {
+	if i.DANE == nil || i.DANE.Error != nil || len(i.DANE.Records) == 0 {
+		return true
+	}
+	for _, r := range i.DANE.Records {
+		if r.Matched {
+			return true
+		}
+	}
+	return false
+}
+
+// lookupDANE fetches the TLSA records of the endpoint and matches each of
+// them against the chain of resp
+func lookupDANE(ctx context.Context, resolver string, source []net.IP, target Target, resp Response, timeout time.Duration) *DANEResult {
+	result := &DANEResult{Name: fmt.Sprintf("_%s._tcp.%s", target.Port, strings.TrimSuffix(target.Host, "."))}
+	server, err := resolverAddress(resolver)
+	if err != nil {
+		result.Error = err
+		return result
+	}
+	answers, err := queryDNS(ctx, server, source, result.Name, typeTLSA, timeout)
+	if err != nil && !errors.Is(err, errNoSuchDomain) {
+		result.Error = fmt.Errorf("TLSA lookup failed: %w", err)
+		return result
+	}
+	for _, a := range answers {
+		body, ok := a.Body.(*dnsmessage.UnknownResource)
+		if !ok || a.Header.Type != typeTLSA {
+			continue
+		}
+		if len(body.Data) < 4 {
+			result.Records = append(result.Records, TLSARecord{Error: errors.New("invalid TLSA record")})
+			continue
+		}
+		r := TLSARecord{
+			Usage:        body.Data[0],
+			Selector:     body.Data[1],
+			MatchingType: body.Data[2],
+			Data:         hex.EncodeToString(body.Data[3:]),
+		}
+		r.Matched, r.Error = matchTLSA(r, body.Data[3:], resp)
+		result.Records = append(result.Records, r)
+	}
+	return result
+}
+
+// forChain returns the records of d matched against the chain of resp, for
+// the backends of a hostname presenting their own chain
+func (d *DANEResult) forChain(resp Response) *DANEResult {
+	if d == nil {
+		return nil
+	}
+	result := &DANEResult{Name: d.Name, Error: d.Error}
+	for _, r := range d.Records {
+		// Records too short to parse carry no data
+		if data, err := hex.DecodeString(r.Data); err == nil && len(data) > 0 {
+			r.Matched, r.Error = matchTLSA(r, data, resp)
+		}
+		result.Records = append(result.Records, r)
+	}
+	return result
+}
+
+// matchTLSA compares a record to the end entity or the issuing certificates
+// of the chain, depending on its usage. PKIX usages also require the chain
+// to pass verification.
+func matchTLSA(r TLSARecord, data []byte, resp Response) (bool, error) {
+	var candidates []*x509.Certificate
+	switch r.Usage {
+	case TLSAUsagePKIXEE, TLSAUsageDANEEE:
+		candidates = resp.Chain[:1]
+	case TLSAUsagePKIXTA:
+		// The trust anchor has to be part of the validated path
+		if len(resp.VerifiedChain) > 1 {
+			candidates = resp.VerifiedChain[1:]
+		}
+	case TLSAUsageDANETA:
+		candidates = resp.Chain[1:]
+	default:
+		return false, fmt.Errorf("unknown TLSA usage %d", r.Usage)
+	}
+
+	for _, c := range candidates {
+		var selected []byte
+		switch r.Selector {
+		case 0:
+			selected = c.Raw
+		case 1:
+			selected = c.RawSubjectPublicKeyInfo
+		default:
+			return false, fmt.Errorf("unknown TLSA selector %d", r.Selector)
+		}
+		switch r.MatchingType {
+		case 0:
+		case 1:
+			sum := sha256.Sum256(selected)
+			selected = sum[:]
+		case 2:
+			sum := sha512.Sum512(selected)
+			selected = sum[:]
+		default:
+			return false, fmt.Errorf("unknown TLSA matching type %d", r.MatchingType)
+		}
+		if bytes.Equal(selected, data) {
+			if r.Usage == TLSAUsagePKIXEE && !resp.Verified {
+				return false, errors.New("certificate matches but fails PKIX verification")
+			}
+			return true, nil
+		}
+	}
+	return false, nil
+}
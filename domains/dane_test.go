@@ -0,0 +1,134 @@
+package domains
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/sha512"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"testing"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// tlsaData returns the association data of c for a selector and matching type
+func tlsaData(c *x509.Certificate, selector, matchingType uint8) []byte {
+	data := c.Raw
+	if selector == 1 {
+		data = c.RawSubjectPublicKeyInfo
+	}
+	switch matchingType {
+	case 1:
+		sum := sha256.Sum256(data)
+		return sum[:]
+	case 2:
+		sum := sha512.Sum512(data)
+		return sum[:]
+	}
+	return data
+}
+
+// newTestChain returns a root, an intermediate and a leaf issued by it
+func newTestChain(t *testing.T) (root, intermediate, leaf *testCert) {
+	t.Helper()
+	root = newTestCA(t, "Test Root")
+	intermediate = newTestCert(t, &x509.Certificate{
+		Subject:               pkix.Name{CommonName: "Test Intermediate"},
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}, root)
+	leaf = newTestLeaf(t, intermediate, "www.example.test")
+	return root, intermediate, leaf
+}
+
+func TestMatchTLSA(t *testing.T) {
+	root, intermediate, leaf := newTestChain(t)
+	verified := Response{
+		Chain:         []*x509.Certificate{leaf.cert, intermediate.cert},
+		VerifiedChain: []*x509.Certificate{leaf.cert, intermediate.cert, root.cert},
+		Verified:      true,
+	}
+	unverified := Response{Chain: verified.Chain}
+
+	tests := []struct {
+		name      string
+		usage     uint8
+		selector  uint8
+		matching  uint8
+		cert      *x509.Certificate
+		resp      Response
+		want      bool
+		wantError bool
+	}{
+		{"DANE-EE full cert", TLSAUsageDANEEE, 0, 0, leaf.cert, unverified, true, false},
+		{"DANE-EE SPKI SHA-256", TLSAUsageDANEEE, 1, 1, leaf.cert, unverified, true, false},
+		{"DANE-EE SPKI SHA-512", TLSAUsageDANEEE, 1, 2, leaf.cert, unverified, true, false},
+		{"DANE-EE intermediate", TLSAUsageDANEEE, 0, 1, intermediate.cert, unverified, false, false},
+		{"DANE-TA cert SHA-256", TLSAUsageDANETA, 0, 1, intermediate.cert, unverified, true, false},
+		{"DANE-TA SPKI SHA-512", TLSAUsageDANETA, 1, 2, intermediate.cert, unverified, true, false},
+		{"DANE-TA leaf", TLSAUsageDANETA, 1, 1, leaf.cert, unverified, false, false},
+		{"DANE-TA root not presented", TLSAUsageDANETA, 1, 1, root.cert, verified, false, false},
+		{"PKIX-TA root", TLSAUsagePKIXTA, 1, 1, root.cert, verified, true, false},
+		{"PKIX-TA intermediate", TLSAUsagePKIXTA, 0, 2, intermediate.cert, verified, true, false},
+		{"PKIX-TA unverified", TLSAUsagePKIXTA, 1, 1, root.cert, unverified, false, false},
+		{"PKIX-EE", TLSAUsagePKIXEE, 1, 1, leaf.cert, verified, true, false},
+		{"PKIX-EE unverified", TLSAUsagePKIXEE, 1, 1, leaf.cert, unverified, false, true},
+		{"unknown usage", 4, 1, 1, leaf.cert, verified, false, true},
+		{"unknown selector", TLSAUsageDANEEE, 2, 1, leaf.cert, verified, false, true},
+		{"unknown matching type", TLSAUsageDANEEE, 1, 3, leaf.cert, verified, false, true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := TLSARecord{Usage: tt.usage, Selector: tt.selector, MatchingType: tt.matching}
+			got, err := matchTLSA(r, tlsaData(tt.cert, tt.selector, tt.matching), tt.resp)
+			if got != tt.want || (err != nil) != tt.wantError {
+				t.Errorf("got %v, %v; want %v, error %v", got, err, tt.want, tt.wantError)
+			}
+		})
+	}
+}
+
+// tlsaRR returns a TLSA record for the DNS stub
+func tlsaRR(usage, selector, matchingType uint8, data []byte) dnsmessage.ResourceBody {
+	return &dnsmessage.UnknownResource{Type: typeTLSA, Data: append([]byte{usage, selector, matchingType}, data...)}
+}
+
+func TestLookupDANE(t *testing.T) {
+	_, intermediate, leaf := newTestChain(t)
+	other := newTestLeaf(t, intermediate, "www.example.test")
+	resolver := newDNSStub(t, dnsZone{
+		"_443._tcp.www.example.test.": {typeTLSA: {
+			tlsaRR(TLSAUsageDANEEE, 1, 1, tlsaData(other.cert, 1, 1)),
+			tlsaRR(TLSAUsageDANEEE, 1, 1, tlsaData(leaf.cert, 1, 1)),
+		}},
+		"_25._tcp.mail.example.test.": {typeTLSA: {tlsaRR(TLSAUsageDANETA, 1, 1, tlsaData(other.cert, 1, 1))}},
+	})
+	resp := Response{Chain: []*x509.Certificate{leaf.cert, intermediate.cert}}
+	lookup := func(host, port string) *DANEResult {
+		return lookupDANE(context.Background(), resolver, nil, Target{Host: host, Port: port}, resp, time.Second)
+	}
+
+	r := lookup("www.example.test", "443")
+	if r.Error != nil || r.Name != "_443._tcp.www.example.test" || len(r.Records) != 2 {
+		t.Fatalf("got %+v", r)
+	}
+	if r.Records[0].Matched || !r.Records[1].Matched {
+		t.Errorf("got records %+v, want the second one matched", r.Records)
+	}
+	resp.DANE = r
+	if !resp.DANEMatched() {
+		t.Error("DANEMatched is false with a matching record")
+	}
+
+	resp.DANE = lookup("mail.example.test", "25")
+	if len(resp.DANE.Records) != 1 || resp.DANEMatched() {
+		t.Errorf("got %+v, want a record not matching", resp.DANE)
+	}
+
+	resp.DANE = lookup("none.example.test", "443")
+	if resp.DANE.Error != nil || len(resp.DANE.Records) != 0 || !resp.DANEMatched() {
+		t.Errorf("got %+v without records", resp.DANE)
+	}
+}
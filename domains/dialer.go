@@ -0,0 +1,230 @@
+package domains
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// dialer opens the connections of a probe, through its proxy or directly
+// from the source address, resolving hostnames with the configured resolver
+type dialer struct {
+	proxy    *url.URL
+	source   []net.IP
+	resolver *net.Resolver
+	limiter  *limiter
+	// address is the IP of the last direct connection attempt
+	address string
+}
+
+// SourceAddresses returns the local addresses probes are bound to, addr
+// itself or the addresses of iface, none when both are empty
+func SourceAddresses(addr, iface string) ([]net.IP, error) {
+	switch {
+	case addr != "" && iface != "":
+		return nil, errors.New("source address and interface are mutually exclusive")
+	case addr != "":
+		ip := net.ParseIP(addr)
+		if ip == nil {
+			return nil, fmt.Errorf("invalid source address %s", addr)
+		}
+		return []net.IP{ip}, nil
+	case iface != "":
+		i, err := net.InterfaceByName(iface)
+		if err != nil {
+			return nil, fmt.Errorf("invalid interface %s: %w", iface, err)
+		}
+		addrs, err := i.Addrs()
+		if err != nil {
+			return nil, fmt.Errorf("invalid interface %s: %w", iface, err)
+		}
+		var ips []net.IP
+		for _, a := range addrs {
+			// Link-local addresses need a zone and rarely route anywhere
+			if ipNet, ok := a.(*net.IPNet); ok && !ipNet.IP.IsLinkLocalUnicast() {
+				ips = append(ips, ipNet.IP)
+			}
+		}
+		if len(ips) == 0 {
+			return nil, fmt.Errorf("interface %s has no usable address", iface)
+		}
+		return ips, nil
+	}
+	return nil, nil
+}
+
+// dialer returns the dialer of the probes of addr
+func (o Options) dialer(addr string) (*dialer, error) {
+	d := &dialer{source: o.Source, resolver: o.netResolver(), limiter: o.limiter}
+	if o.direct {
+		return d, nil
+	}
+	var err error
+	d.proxy, err = o.proxyFor(addr)
+	return d, err
+}
+
+// httpClient returns the client of the OCSP and CRL requests, dialing the
+// way probes do: through their proxy, from their source address, resolving
+// with their resolver. It holds no limiter slots since the responders are not the
+// probed hosts, and keeps no idle connections.
+func (o Options) httpClient() *http.Client {
+	o.direct = false
+	return &http.Client{Transport: &http.Transport{
+		DisableKeepAlives: true,
+		DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+			d, err := o.dialer(addr)
+			if err != nil {
+				return nil, fmt.Errorf("%w: %w", ErrProxy, err)
+			}
+			d.limiter = nil
+			deadline, ok := ctx.Deadline()
+			if !ok {
+				deadline = time.Now().Add(o.Timeout)
+			}
+			return d.dial(ctx, addr, deadline)
+		},
+	}}
+}
+
+// netResolver returns the resolver of hostnames, querying the configured
+// DNS server from the source address
+func (o Options) netResolver() *net.Resolver {
+	if o.Resolver == "" && len(o.Source) == 0 {
+		return net.DefaultResolver
+	}
+	return &net.Resolver{
+		PreferGo: true,
+		Dial: func(ctx context.Context, network, address string) (net.Conn, error) {
+			if o.Resolver != "" {
+				server, err := resolverAddress(o.Resolver)
+				if err != nil {
+					return nil, err
+				}
+				address = server
+			}
+			host, _, _ := net.SplitHostPort(address)
+			return netDialer(o.Source, network, net.ParseIP(host)).DialContext(ctx, network, address)
+		},
+	}
+}
+
+// dial connects to addr through the proxy, or resolves it and tries each of
+// its addresses in turn. Direct connections hold a slot of their IP until
+// they are closed.
+func (d *dialer) dial(ctx context.Context, addr string, deadline time.Time) (net.Conn, error) {
+	if d.proxy != nil {
+		forward := netDialer(d.source, "tcp", nil)
+		forward.Deadline = deadline
+		forward.Resolver = d.resolver
+		var (
+			conn net.Conn
+			err  error
+		)
+		if strings.HasPrefix(d.proxy.Scheme, "socks5") {
+			conn, err = dialSOCKS5(ctx, addr, d.proxy, forward)
+		} else {
+			conn, err = dialConnect(ctx, addr, d.proxy, forward, deadline)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("%w: %s: %w", ErrProxy, d.proxy.Redacted(), err)
+		}
+		return conn, nil
+	}
+
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	ips, err := d.resolve(ctx, host, deadline)
+	if err != nil {
+		return nil, err
+	}
+	var conn net.Conn
+	for _, ip := range ips {
+		d.address = ip.String()
+		release, ok := d.limiter.acquire(ctx, d.address)
+		if !ok {
+			return nil, ctx.Err()
+		}
+		forward := netDialer(d.source, "tcp", ip)
+		forward.Deadline = deadline
+		conn, err = forward.DialContext(ctx, "tcp", net.JoinHostPort(ip.String(), port))
+		if err == nil {
+			return &slotConn{Conn: conn, release: release}, nil
+		}
+		release()
+	}
+	return nil, err
+}
+
+// slotConn releases its limiter slot once closed
+type slotConn struct {
+	net.Conn
+	release func()
+}
+
+func (c *slotConn) Close() error {
+	defer c.release()
+	return c.Conn.Close()
+}
+
+// resolve returns the addresses of host reachable from the source address
+func (d *dialer) resolve(ctx context.Context, host string, deadline time.Time) ([]net.IP, error) {
+	addrs := []net.IPAddr{{IP: net.ParseIP(host)}}
+	if addrs[0].IP == nil {
+		ctx, cancel := context.WithDeadline(ctx, deadline)
+		defer cancel()
+		var err error
+		addrs, err = d.resolver.LookupIPAddr(ctx, host)
+		if err != nil {
+			return nil, err
+		}
+	}
+	var ips []net.IP
+	for _, a := range addrs {
+		if len(d.source) == 0 || sourceFor(d.source, a.IP) != nil {
+			ips = append(ips, a.IP)
+		}
+	}
+	if len(ips) == 0 {
+		return nil, fmt.Errorf("no address of %s matches the source address family", host)
+	}
+	return ips, nil
+}
+
+// netDialer returns a dialer bound to the source address of the family of
+// remote, the first source address when remote is unknown
+func netDialer(source []net.IP, network string, remote net.IP) *net.Dialer {
+	d := &net.Dialer{}
+	ip := sourceFor(source, remote)
+	if ip == nil && remote == nil && len(source) > 0 {
+		ip = source[0]
+	}
+	switch {
+	case ip == nil:
+	case strings.HasPrefix(network, "udp"):
+		d.LocalAddr = &net.UDPAddr{IP: ip}
+	default:
+		d.LocalAddr = &net.TCPAddr{IP: ip}
+	}
+	return d
+}
+
+// sourceFor returns the source address of the family of remote
+func sourceFor(source []net.IP, remote net.IP) net.IP {
+	if remote == nil {
+		return nil
+	}
+	for _, ip := range source {
+		if (ip.To4() == nil) == (remote.To4() == nil) {
+			return ip
+		}
+	}
+	return nil
+}
@@ -0,0 +1,133 @@
+package domains
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net"
+	"os"
+	"strings"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// resolvConf is where the system resolver is read from when none is set
+const resolvConf = "/etc/resolv.conf"
+
+// errNoSuchDomain is returned for NXDOMAIN answers
+var errNoSuchDomain = errors.New("no such domain")
+
+// resolverAddress returns server as host:port, defaulting the port to 53,
+// or the first nameserver of resolv.conf when server is empty
+func resolverAddress(server string) (string, error) {
+	if server == "" {
+		f, err := os.Open(resolvConf)
+		if err != nil {
+			return "", fmt.Errorf("no resolver configured: %w", err)
+		}
+		defer f.Close()
+		scanner := bufio.NewScanner(f)
+		for scanner.Scan() {
+			fields := strings.Fields(scanner.Text())
+			if len(fields) >= 2 && fields[0] == "nameserver" {
+				server = fields[1]
+				break
+			}
+		}
+		if server == "" {
+			return "", fmt.Errorf("no nameserver in %s", resolvConf)
+		}
+	}
+	if _, _, err := net.SplitHostPort(server); err != nil {
+		server = net.JoinHostPort(strings.Trim(server, "[]"), "53")
+	}
+	return server, nil
+}
+
+// queryDNS sends a recursive query for name and qtype to server over UDP,
+// from the source address, retrying over TCP when the answer is truncated,
+// and returns the answers
+func queryDNS(ctx context.Context, server string, source []net.IP, name string, qtype dnsmessage.Type, timeout time.Duration) ([]dnsmessage.Resource, error) {
+	qname, err := dnsmessage.NewName(strings.TrimSuffix(name, ".") + ".")
+	if err != nil {
+		return nil, err
+	}
+	id := uint16(rand.Intn(1 << 16))
+	query, err := (&dnsmessage.Message{
+		Header:    dnsmessage.Header{ID: id, RecursionDesired: true},
+		Questions: []dnsmessage.Question{{Name: qname, Type: qtype, Class: dnsmessage.ClassINET}},
+	}).Pack()
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	msg, err := exchangeDNS(ctx, "udp", server, source, query)
+	if err == nil && msg.Truncated {
+		msg, err = exchangeDNS(ctx, "tcp", server, source, query)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if msg.ID != id {
+		return nil, errors.New("DNS answer ID mismatch")
+	}
+	switch msg.RCode {
+	case dnsmessage.RCodeSuccess:
+		return msg.Answers, nil
+	case dnsmessage.RCodeNameError:
+		return nil, errNoSuchDomain
+	default:
+		return nil, fmt.Errorf("DNS query for %s failed: %s", name, msg.RCode)
+	}
+}
+
+func exchangeDNS(ctx context.Context, network, server string, source []net.IP, query []byte) (*dnsmessage.Message, error) {
+	host, _, _ := net.SplitHostPort(server)
+	conn, err := netDialer(source, network, net.ParseIP(host)).DialContext(ctx, network, server)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+	if deadline, ok := ctx.Deadline(); ok {
+		conn.SetDeadline(deadline)
+	}
+
+	var answer []byte
+	if network == "tcp" {
+		// Messages are prefixed with their length over TCP
+		if _, err := conn.Write(append(binary.BigEndian.AppendUint16(nil, uint16(len(query))), query...)); err != nil {
+			return nil, err
+		}
+		var length [2]byte
+		if _, err := io.ReadFull(conn, length[:]); err != nil {
+			return nil, err
+		}
+		answer = make([]byte, binary.BigEndian.Uint16(length[:]))
+		if _, err := io.ReadFull(conn, answer); err != nil {
+			return nil, err
+		}
+	} else {
+		if _, err := conn.Write(query); err != nil {
+			return nil, err
+		}
+		answer = make([]byte, 65535)
+		n, err := conn.Read(answer)
+		if err != nil {
+			return nil, err
+		}
+		answer = answer[:n]
+	}
+
+	var msg dnsmessage.Message
+	if err := msg.Unpack(answer); err != nil {
+		return nil, fmt.Errorf("invalid DNS answer: %w", err)
+	}
+	return &msg, nil
+}
@@ -0,0 +1,85 @@
+package domains
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// dnsZone answers the questions of the DNS stub, by name and type
+type dnsZone map[string]map[dnsmessage.Type][]dnsmessage.ResourceBody
+
+// newDNSStub serves zone over UDP and returns its address. Names missing
+// from zone get NXDOMAIN, missing types an empty answer.
+func newDNSStub(t *testing.T, zone dnsZone) string {
+	t.Helper()
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { pc.Close() })
+	go func() {
+		buf := make([]byte, 512)
+		for {
+			n, addr, err := pc.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			var msg dnsmessage.Message
+			if err := msg.Unpack(buf[:n]); err != nil || len(msg.Questions) != 1 {
+				continue
+			}
+			q := msg.Questions[0]
+			msg.Response, msg.Authoritative, msg.RecursionAvailable = true, true, true
+			types, ok := zone[q.Name.String()]
+			if !ok {
+				msg.RCode = dnsmessage.RCodeNameError
+			}
+			// Like a recursive resolver, answer with the CNAME of the name
+			// followed by the records of its target
+			name := q.Name
+			for _, body := range types[dnsmessage.TypeCNAME] {
+				msg.Answers = append(msg.Answers, dnsmessage.Resource{
+					Header: dnsmessage.ResourceHeader{Name: name, Type: dnsmessage.TypeCNAME, Class: dnsmessage.ClassINET, TTL: 60},
+					Body:   body,
+				})
+				name = body.(*dnsmessage.CNAMEResource).CNAME
+				types = zone[name.String()]
+			}
+			if q.Type != dnsmessage.TypeCNAME {
+				for _, body := range types[q.Type] {
+					msg.Answers = append(msg.Answers, dnsmessage.Resource{
+						Header: dnsmessage.ResourceHeader{Name: name, Type: q.Type, Class: dnsmessage.ClassINET, TTL: 60},
+						Body:   body,
+					})
+				}
+			}
+			answer, err := msg.Pack()
+			if err != nil {
+				continue
+			}
+			pc.WriteTo(answer, addr)
+		}
+	}()
+	return pc.LocalAddr().String()
+}
+
+func TestQueryDNS(t *testing.T) {
+	server := newDNSStub(t, dnsZone{
+		"a.example.test.": {dnsmessage.TypeA: {&dnsmessage.AResource{A: [4]byte{192, 0, 2, 1}}}},
+	})
+	answers, err := queryDNS(context.Background(), server, nil, "a.example.test", dnsmessage.TypeA, time.Second)
+	if err != nil || len(answers) != 1 {
+		t.Fatalf("got %v, %v", answers, err)
+	}
+	if a := answers[0].Body.(*dnsmessage.AResource).A; a != [4]byte{192, 0, 2, 1} {
+		t.Errorf("got address %v", a)
+	}
+	if _, err := queryDNS(context.Background(), server, nil, "b.example.test", dnsmessage.TypeA, time.Second); !errors.Is(err, errNoSuchDomain) {
+		t.Errorf("got %v for a missing name, want %v", err, errNoSuchDomain)
+	}
+}
@@ -1,180 +1,584 @@
 package domains
 
 import (
+	"context"
 	"crypto/tls"
+	"crypto/x509"
 	"crypto/x509/pkix"
+	"errors"
 	"fmt"
 	"math/big"
 	"net"
-	"os"
 	"sort"
-	"strings"
+	"sync"
 	"time"
-	"unicode/utf8"
 
 	"github.com/rs/zerolog/log"
 )
 
-const (
-	DefaultReportFile = "./report.md"
-)
-
 type Response struct {
-	Domain              string
-	Environment         string
+	Domain      string
+	Port        string
+	Protocol    string
+	Environment string
+	// Address is the IP the target resolved to and the probe connected to,
+	// set on failed probes once resolution succeeded
+	Address string
+	// Connect is the address dialed when it differs from the target
+	Connect string
+	// Proxy is the proxy the probe went through, without its password
+	Proxy string
+	// ServerName is the SNI sent, empty when none was, VerifyName the name
+	// the certificate was verified against
+	ServerName string
+	VerifyName string
+	// TrustStore names the roots the chain was validated by
+	TrustStore string
+	// ClientCertRequested tells whether the server asked for a client
+	// certificate, AcceptableCAs are the issuers it advertised
+	ClientCertRequested bool
+	AcceptableCAs       []string
 	NotBefore, NotAfter time.Time
 	Issuer              pkix.Name
 	Subject             pkix.Name
 	SAN                 []string
 	SerialNumber        *big.Int
-	Error               error
+	// Chain is the certificate chain as presented by the server, leaf first
+	Chain []*x509.Certificate
+	// VerifiedChain is the chain built during verification, leaf to root
+	VerifiedChain []*x509.Certificate
+	// Verified tells whether the presented chain passed verification,
+	// VerifyError is why it did not. The certificate data is filled either way.
+	Verified    bool
+	VerifyError error
+	// OCSPStapled is the OCSP response stapled by the server, OCSPResponder
+	// the one fetched from the certificate responder when enabled
+	OCSPStapled   *OCSPResult
+	OCSPResponder *OCSPResult
+	// CRL holds the CRL lookups of each certificate of the chain
+	CRL []CRLResult
+	// SCTs are the signed certificate timestamps of the leaf from every source
+	SCTs []SCT
+	// CAA is the CAA record set of the domain, when looked up
+	CAA *CAAResult
+	// DANE holds the TLSA records of the endpoint, when looked up
+	DANE *DANEResult
+	// HTTP is the answer to a request sent over the TLS connection, when
+	// enabled for https targets
+	HTTP *HTTPResult
+	// TLSVersion, CipherSuite and ALPN are negotiated by the default handshake
+	TLSVersion  uint16
+	CipherSuite uint16
+	ALPN        string
+	// Scan lists the versions and suites accepted, when scanning is enabled
+	Scan *ScanResult
+	// Findings are the policy findings of the endpoint, evaluated once when
+	// the probe completes
+	Findings []Finding
+	// Attempts is the number of handshakes made, AttemptErrors their failures
+	Attempts      int
+	AttemptErrors []error
+	Error         error
+	// ErrorCode is the stable classification of Error
+	ErrorCode ErrorCode
+	// Backends holds one response per resolved address when all addresses
+	// of the hostname are probed
+	Backends []Response
+}
+
+// Expiry returns the earliest NotAfter of the presented chain, including
+// the chains of every backend
+func (i Response) Expiry() time.Time {
+	expiry := i.NotAfter
+	if c := i.ExpiringCert(); c != nil {
+		expiry = c.NotAfter
+	}
+	for _, b := range i.Backends {
+		if e := b.Expiry(); !e.IsZero() && (expiry.IsZero() || e.Before(expiry)) {
+			expiry = e
+		}
+	}
+	return expiry
+}
+
+// SerialMismatch reports whether the backends of a hostname serve
+// different leaf certificates
+func (i Response) SerialMismatch() bool {
+	var serial *big.Int
+	for _, b := range i.Backends {
+		if b.SerialNumber == nil {
+			continue
+		}
+		if serial == nil {
+			serial = b.SerialNumber
+		} else if serial.Cmp(b.SerialNumber) != 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// HasCert tells whether a certificate was captured, even an invalid one
+func (i Response) HasCert() bool {
+	return len(i.Chain) > 0
 }
 
+// ExpiringCert returns the first certificate to expire in the presented chain
+func (i Response) ExpiringCert() *x509.Certificate {
+	var first *x509.Certificate
+	for _, c := range i.Chain {
+		if first == nil || c.NotAfter.Before(first.NotAfter) {
+			first = c
+		}
+	}
+	return first
+}
+
+// ChainOrdered reports whether each presented certificate is signed by the next one
+func (i Response) ChainOrdered() bool {
+	for k := 0; k+1 < len(i.Chain); k++ {
+		if i.Chain[k].CheckSignatureFrom(i.Chain[k+1]) != nil {
+			return false
+		}
+	}
+	return true
+}
+
+// KnownError returns a human readable description of the failure class,
+// or the raw error when it could not be classified
 func (i Response) KnownError() string {
-	if i.Error != nil {
-		switch {
-		case strings.HasSuffix(i.Error.Error(), "i/o timeout"):
-			return "connexion timeout"
-		case strings.HasSuffix(i.Error.Error(), "certificate name does not match input"):
-			return "certificate SAN don't include domain"
-		case strings.HasSuffix(i.Error.Error(), "no such host"):
-			return "no DNS entry for this host"
-		case strings.HasPrefix(i.Error.Error(), "tls: failed to verify certificate: x509: certificate is valid for"):
-			return "invalid certificate"
-		default:
+	switch i.ErrorCode {
+	case ErrCodeNone:
+		if i.Error != nil {
 			return i.Error.Error()
 		}
+		return ""
+	case ErrCodeUnknown, ErrCodeCancelled, ErrCodeInvalidTarget:
+		return i.Error.Error()
+	default:
+		return i.ErrorCode.Description()
+	}
+}
+
+// Endpoint returns the host:port that was actually probed, prefixed with
+// the protocol when it is not plain https and followed by the address
+// dialed when it was overridden
+func (i Response) Endpoint() string {
+	if i.Port == "" {
+		return i.Domain
+	}
+	endpoint := net.JoinHostPort(i.Domain, i.Port)
+	if i.Protocol != "" && i.Protocol != DefaultProtocol {
+		endpoint = i.Protocol + "://" + endpoint
+	}
+	if i.Connect != "" {
+		endpoint += " via " + i.Connect
 	}
-	return ""
+	return endpoint
 }
 
 // FilterValue implement the list.Model Item interface
 func (i Response) FilterValue() string {
-	search := fmt.Sprintf("%v %v %v", i.Domain, i.Issuer, i.NotAfter)
+	search := fmt.Sprintf("%v %v %v", i.Endpoint(), i.Issuer, i.NotAfter)
 	return search
 }
 
 // Title is required by list.Model to display Item main data
-func (i Response) Title() string { return i.Domain }
+func (i Response) Title() string { return i.Endpoint() }
 
 // Description is required by list.Model to display Item description
 func (i Response) Description() string { return i.Environment }
 
-func TestDomain(domain, env string, timeO int, out chan<- Response) {
-	var resp Response
-	log.Debug().Msgf("SSL query for %v", domain)
+// Options tune how a single target is probed
+type Options struct {
+	Timeout time.Duration
+	// Retries is the number of extra attempts made on transient failures
+	Retries int
+	// Backoff is the base delay between attempts, doubled on each retry
+	Backoff time.Duration
+	// AllAddresses probes every resolved address of a hostname
+	AllAddresses bool
+	// Connect, SNI and VerifyName apply to targets that don't set their own,
+	// NoSNI sends no server name
+	Connect    string
+	SNI        string
+	NoSNI      bool
+	VerifyName string
+	// TrustStore replaces or extends the system roots, nil for system only
+	TrustStore *TrustStore
+	// ClientCert is sent to servers requesting mutual TLS
+	ClientCert *tls.Certificate
+	// OCSP queries the OCSP responder of each certificate
+	OCSP bool
+	// CRL checks the chain against the CRLs of its distribution points
+	CRL *CRLCache
+	// Scan enumerates the protocol versions and cipher suites accepted
+	Scan bool
+	// CTLogs verifies SCT signatures, nil to only decode them
+	CTLogs *CTLogList
+	// CAA looks up the CAA records of each hostname
+	CAA bool
+	// DANE matches the TLSA records of each endpoint against its chain
+	DANE bool
+	// HTTP sends a request over the TLS connection of https targets
+	HTTP bool
+	// Proxy is the HTTP CONNECT or SOCKS5 proxy URL probes go through,
+	// HTTPS_PROXY when empty and none when ProxyDirect. NO_PROXY applies.
+	Proxy string
+	// Resolver is the DNS server (host or host:port) hostnames, CAA and
+	// TLSA records are resolved with. When empty hostnames go through the
+	// system resolver and records to the first nameserver of resolv.conf.
+	Resolver string
+	// Source are the local addresses probes and DNS queries are bound to,
+	// the one of the family of each destination is used
+	Source []net.IP
 
-	nDialer := net.Dialer{
-		Timeout: time.Duration(timeO) * time.Second,
-	}
-	d := tls.Dialer{
-		NetDialer: &nDialer,
+	// limiter holds the per host slots of the runner, nil outside of it
+	limiter *limiter
+	// direct skips the proxy of the probed addresses, for the backends of a
+	// hostname NO_PROXY already matched
+	direct bool
+}
+
+// cancelledError describes why the run context ended
+func cancelledError(ctx context.Context) error {
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return fmt.Errorf("%w: run deadline exceeded", ErrCancelled)
 	}
+	return fmt.Errorf("%w: run interrupted", ErrCancelled)
+}
+
+func TestDomain(ctx context.Context, domain, env string, opts Options, out chan<- Response) {
+	log.Debug().Msgf("SSL query for %v", domain)
 
-	conn, err := d.Dial("tcp", domain+":443")
+	target, err := ParseTarget(domain)
+	if err == nil {
+		target, err = target.withDefaults(opts)
+	}
 	if err != nil {
-		log.Debug().Msgf("Error in d.Dial for domain %s", domain)
-		resp = Response{
+		log.Debug().Msgf("Error parsing target %s: %v", domain, err)
+		out <- Response{
 			Domain:      domain,
 			Environment: env,
 			Error:       err,
+			ErrorCode:   Classify(err),
 		}
-	} else {
-		tlsConn := conn.(*tls.Conn)
+		return
+	}
 
-		resp = Response{
-			Domain:       domain,
-			Environment:  env,
-			NotBefore:    tlsConn.ConnectionState().PeerCertificates[0].NotBefore,
-			NotAfter:     tlsConn.ConnectionState().PeerCertificates[0].NotAfter,
-			Issuer:       tlsConn.ConnectionState().PeerCertificates[0].Issuer,
-			SerialNumber: tlsConn.ConnectionState().PeerCertificates[0].SerialNumber,
-			Subject:      tlsConn.ConnectionState().PeerCertificates[0].Subject,
-			SAN:          tlsConn.ConnectionState().PeerCertificates[0].DNSNames,
-			Error:        err,
-		}
-		log.Debug().Msgf("SSL query completed for %v", domain)
+	// A proxy reaches a single backend of the hostname, and
+	// NO_PROXY is matched against the hostname rather than each address
+	allAddresses := opts.AllAddresses && target.Connect == "" && net.ParseIP(target.Host) == nil
+	if allAddresses {
+		if proxy, err := opts.proxyFor(target.DialAddress()); err != nil || proxy != nil {
+			allAddresses = false
+		}
 	}
+	var resp Response
+	if allAddresses {
+		resp = probeAllAddresses(ctx, target, env, opts)
+	} else {
+		resp = probeAddress(ctx, target, env, opts, target.DialAddress())
+	}
+	// CAA and TLSA records belong to the hostname, they are looked up once
+	// and each backend is held to them
+	if opts.CAA && resp.HasCert() && net.ParseIP(target.Host) == nil {
+		resp.CAA = lookupCAA(ctx, opts.Resolver, opts.Source, target.Host, opts.Timeout)
+	}
+	if opts.DANE && resp.HasCert() && net.ParseIP(target.Host) == nil {
+		resp.DANE = lookupDANE(ctx, opts.Resolver, opts.Source, target, resp, opts.Timeout)
+	}
+	for k, b := range resp.Backends {
+		if b.HasCert() {
+			resp.Backends[k].CAA = resp.CAA
+			resp.Backends[k].DANE = resp.DANE.forChain(b)
+		}
+		resp.Backends[k].Findings = resp.Backends[k].evaluate()
+	}
+	resp.Findings = resp.evaluate()
 	out <- resp
 }
 
-func CreateReport(domains []Response, queries []string, fileName string, stdOut bool) {
-	var file strings.Builder
+// maxBackendProbes bounds the backends of a target probed at once
+const maxBackendProbes = 4
 
-	headers := []string{"Domain", "Expiration", "Issuer"}
+// probeAllAddresses resolves every A/AAAA record of the target and probes
+// each address with the hostname as SNI, going through the run limits. The
+// returned response mirrors the first successful backend and lists them all
+// in Backends.
+func probeAllAddresses(ctx context.Context, target Target, env string, opts Options) Response {
+	ips, err := opts.netResolver().LookupIPAddr(ctx, target.Host)
+	if err == nil && len(ips) == 0 {
+		err = &net.DNSError{Err: "no address", Name: target.Host, IsNotFound: true}
+	}
+	if err != nil {
+		if ctx.Err() != nil {
+			err = cancelledError(ctx)
+		}
+		return Response{
+			Domain:      target.Host,
+			Port:        target.Port,
+			Protocol:    target.Protocol,
+			Environment: env,
+			Error:       err,
+			ErrorCode:   Classify(err),
+		}
+	}
 
-	file.WriteString("# TLS check Domain report\n")
-	file.WriteString("\n")
+	opts.direct = true
+	backends := make([]Response, len(ips))
+	sem := make(chan struct{}, maxBackendProbes)
+	var wg sync.WaitGroup
+	for k, ip := range ips {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(k int, ip net.IPAddr) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			addr := net.JoinHostPort(ip.String(), target.Port)
+			if !opts.limiter.wait(ctx) {
+				err := cancelledError(ctx)
+				backends[k] = Response{
+					Domain:      target.Host,
+					Port:        target.Port,
+					Protocol:    target.Protocol,
+					Environment: env,
+					Address:     ip.String(),
+					Error:       err,
+					ErrorCode:   Classify(err),
+				}
+				return
+			}
+			backends[k] = probeAddress(ctx, target, env, opts, addr)
+		}(k, ip)
+	}
+	wg.Wait()
+	sort.Slice(backends, func(i, j int) bool {
+		return backends[i].Address < backends[j].Address
+	})
 
-	var domainWidth int
-	var issuerWidth int
-	for _, i := range domains {
-		dSize := utf8.RuneCountInString(i.Domain)
-		iSize := utf8.RuneCountInString(i.Issuer.String())
-		if dSize > domainWidth {
-			domainWidth = dSize
+	resp := backends[0]
+	for _, b := range backends {
+		if b.Error == nil {
+			resp = b
+			break
 		}
-		if iSize > issuerWidth {
-			issuerWidth = iSize
+	}
+	resp.Backends = backends
+	return resp
+}
+
+// probeAddress probes target through the given dial address, retrying
+// transient failures
+func probeAddress(ctx context.Context, target Target, env string, opts Options, addr string) Response {
+	var (
+		resp          Response
+		conn          *tls.Conn
+		auth          *clientAuth
+		err           error
+		attempts      int
+		attemptErrors []error
+	)
+	d, proxyErr := opts.dialer(addr)
+	for {
+		attempts++
+		auth = &clientAuth{}
+		if proxyErr != nil {
+			err = fmt.Errorf("%w: %w", ErrProxy, proxyErr)
+			break
 		}
-		if i.Error != nil {
-			eSize := utf8.RuneCountInString(i.Error.Error())
-			if eSize > issuerWidth {
-				issuerWidth = eSize
-			}
+		conn, err = handshake(ctx, target, d, addr, opts.Timeout, tlsConfig(target, opts, auth))
+		if err == nil || ctx.Err() != nil {
+			break
+		}
+		attemptErrors = append(attemptErrors, err)
+		if attempts > opts.Retries || !isTransient(err) {
+			break
+		}
+		log.Debug().Msgf("Transient error for domain %s (%s), retrying: %v", target.Host, addr, err)
+		if !backoff(ctx, opts.Backoff, attempts) {
+			break
 		}
 	}
-	domainWidth = -1 * domainWidth
-	issuerWidth = -1 * issuerWidth
+	if err != nil {
+		log.Debug().Msgf("Error in handshake for domain %s (%s)", target.Host, addr)
+		// Report the run cancellation rather than its side effect on the dial
+		if ctx.Err() != nil {
+			err = cancelledError(ctx)
+		}
+		resp = Response{
+			Domain:        target.Host,
+			Port:          target.Port,
+			Protocol:      target.Protocol,
+			Environment:   env,
+			Attempts:      attempts,
+			AttemptErrors: attemptErrors,
+			Error:         err,
+			ErrorCode:     Classify(err),
+		}
+		// Servers often answer a missing client certificate with a generic
+		// handshake failure
+		if auth.requested && opts.ClientCert == nil && (resp.ErrorCode == ErrCodeHandshake || resp.ErrorCode == ErrCodeTLSAlert) {
+			resp.ErrorCode = ErrCodeClientCert
+		}
+		resp.Address = d.address
+	} else {
+		state := conn.ConnectionState()
+		leaf := state.PeerCertificates[0]
 
-	data := map[string][]Response{}
-	for _, env := range queries {
-		for _, d := range domains {
-			if d.Environment != env {
-				continue
-			}
-			data[env] = append(data[env], d)
-		}
-	}
-
-	now := time.Now()
-	for env, domains := range data {
-		file.WriteString(fmt.Sprintf("## Domains for %v\n", env))
-		file.WriteString("\n")
-		file.WriteString(fmt.Sprintf("| %*s | %-10s | %-*s |\n", domainWidth, headers[0], headers[1], issuerWidth, headers[2]))
-		file.WriteString(fmt.Sprintf("|-%s-|-%-10s-|-%-*s-|\n", strings.Repeat("-", -1*domainWidth), strings.Repeat("-", 10), issuerWidth, strings.Repeat("-", -1*issuerWidth)))
-		sort.Slice(domains, func(i, j int) bool {
-			// We want to move errors (null date value) to the end of list
-			// adding 99 years there - XXX find a more elegant way
-			iDate := domains[i].NotAfter
-			jDate := domains[j].NotAfter
-			if iDate.IsZero() {
-				iDate = now.AddDate(99, 0, 0)
-			}
-			if jDate.IsZero() {
-				jDate = now.AddDate(99, 0, 0)
-			}
-			return iDate.Before(jDate)
-		})
-
-		for _, d := range domains {
-			if d.Error != nil {
-				file.WriteString(fmt.Sprintf("| %*s | %-10s | %-*s |\n", domainWidth, d.Domain, "NA", issuerWidth, d.Error))
-			} else {
-				file.WriteString(fmt.Sprintf("| %*s | %-10s | %-*s |\n", domainWidth, d.Domain, d.NotAfter.Format("2006-01-02"), issuerWidth, d.Issuer.String()))
+		resp = Response{
+			Domain:        target.Host,
+			Port:          target.Port,
+			Protocol:      target.Protocol,
+			Environment:   env,
+			NotBefore:     leaf.NotBefore,
+			NotAfter:      leaf.NotAfter,
+			Issuer:        leaf.Issuer,
+			SerialNumber:  leaf.SerialNumber,
+			Subject:       leaf.Subject,
+			SAN:           leaf.DNSNames,
+			Chain:         state.PeerCertificates,
+			TLSVersion:    state.Version,
+			CipherSuite:   state.CipherSuite,
+			ALPN:          state.NegotiatedProtocol,
+			Attempts:      attempts,
+			AttemptErrors: attemptErrors,
+			Error:         err,
+		}
+		// Through a proxy the remote address is the proxy one
+		if tcpAddr, ok := conn.RemoteAddr().(*net.TCPAddr); ok && d.proxy == nil {
+			resp.Address = tcpAddr.IP.String()
+		}
+		if opts.HTTP && target.Protocol == DefaultProtocol {
+			redial := func() (*tls.Conn, error) {
+				return handshake(ctx, target, d, addr, opts.Timeout, tlsConfig(target, opts, &clientAuth{}))
 			}
+			resp.HTTP = httpCheck(ctx, conn, redial, target, opts.Timeout)
+		}
+		conn.Close()
+
+		// Verification runs on the captured chain so a broken certificate
+		// still reports its data
+		verified, err := verifyChain(state.PeerCertificates, target.VerifyName(), opts.TrustStore.roots())
+		if err != nil {
+			log.Debug().Msgf("Verification failed for domain %s (%s): %v", target.Host, addr, err)
+			resp.VerifyError = err
+			resp.Error = err
+			resp.ErrorCode = Classify(err)
+		} else {
+			resp.Verified = true
+			resp.VerifiedChain = verified[0]
+			resp.TrustStore = opts.TrustStore.validated(verified[0])
+		}
 
+		issuer := issuerOf(resp.VerifiedChain)
+		if issuer == nil {
+			issuer = issuerOf(resp.Chain)
+		}
+		if len(state.OCSPResponse) > 0 {
+			resp.OCSPStapled = parseOCSP(state.OCSPResponse, leaf, issuer)
 		}
-		file.WriteString("\n")
+		if opts.OCSP {
+			resp.OCSPResponder = queryOCSP(ctx, opts.httpClient(), leaf, issuer, opts.Timeout)
+		}
+		resp.SCTs = extractSCTs(leaf, issuer, state.SignedCertificateTimestamps, state.OCSPResponse, opts.CTLogs)
+		if opts.CRL != nil {
+			chain := resp.VerifiedChain
+			if chain == nil {
+				chain = resp.Chain
+			}
+			resp.CRL = opts.CRL.Check(ctx, opts.httpClient(), chain)
+		}
+		if opts.Scan {
+			resp.Scan = scan(ctx, target, d, addr, opts)
+		}
+		log.Debug().Msgf("SSL query completed for %v (%s)", target.Host, addr)
 	}
+	resp.ServerName = target.ServerName()
+	resp.VerifyName = target.VerifyName()
+	resp.Connect = target.Connect
+	if d.proxy != nil {
+		resp.Proxy = d.proxy.Redacted()
+	}
+	resp.ClientCertRequested = auth.requested
+	resp.AcceptableCAs = auth.acceptableCAs
+	return resp
+}
 
-	// We don't save the report on disk if stdOut is requested
-	if stdOut {
-		fmt.Print(file.String())
-	} else {
-		err := os.WriteFile(fileName, []byte(file.String()), 0644)
-		if err != nil {
-			panic(err)
+// tlsConfig returns the client configuration of the default handshake of
+// target. The certificate is not verified, that is left to verifyChain, and
+// client certificate requests are recorded in auth.
+func tlsConfig(target Target, opts Options, auth *clientAuth) *tls.Config {
+	cfg := &tls.Config{
+		ServerName:           target.ServerName(),
+		InsecureSkipVerify:   true,
+		GetClientCertificate: auth.getClientCertificate(opts.ClientCert),
+		// Accept deprecated versions so they get reported rather than refused
+		MinVersion: tls.VersionTLS10,
+	}
+	if target.Protocol == DefaultProtocol {
+		cfg.NextProtos = []string{"h2", "http/1.1"}
+	}
+	return cfg
+}
+
+// handshake connects to addr, performs the protocol STARTTLS upgrade when
+// needed and completes the TLS handshake for target with cfg within timeout
+func handshake(ctx context.Context, target Target, d *dialer, addr string, timeout time.Duration, cfg *tls.Config) (*tls.Conn, error) {
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	deadline := time.Now().Add(timeout)
+	conn, err := d.dial(ctx, addr, deadline)
+	if err != nil {
+		return nil, err
+	}
+	conn.SetDeadline(deadline)
+
+	// Unblock the STARTTLS exchange and handshake when the run is cancelled
+	stop := make(chan struct{})
+	defer close(stop)
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.SetDeadline(time.Now())
+		case <-stop:
+		}
+	}()
+
+	if upgrade := protocols[target.Protocol].startTLS; upgrade != nil {
+		if err := upgrade(conn, target.Host); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("%w: %w", ErrStartTLS, err)
 		}
 	}
+
+	tlsConn := tls.Client(conn, cfg)
+	if err := tlsConn.HandshakeContext(ctx); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	if len(tlsConn.ConnectionState().PeerCertificates) == 0 {
+		tlsConn.Close()
+		return nil, errors.New("tls: server presented no certificate")
+	}
+	return tlsConn, nil
+}
+
+// verifyChain verifies the presented chain against roots, the system ones
+// when nil, for name, failing the same way the tls package does
+func verifyChain(certs []*x509.Certificate, name string, roots *x509.CertPool) ([][]*x509.Certificate, error) {
+	opts := x509.VerifyOptions{
+		DNSName:       name,
+		Roots:         roots,
+		Intermediates: x509.NewCertPool(),
+	}
+	for _, c := range certs[1:] {
+		opts.Intermediates.AddCert(c)
+	}
+	chains, err := certs[0].Verify(opts)
+	if err != nil {
+		return nil, &tls.CertificateVerificationError{UnverifiedCertificates: certs, Err: err}
+	}
+	return chains, nil
 }
@@ -0,0 +1,165 @@
+package domains
+
+import (
+	"bytes"
+	"crypto/tls"
+	"encoding/csv"
+	"net"
+	"testing"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// serveTLSLoop completes the TLS handshake of every connection of l
+func serveTLSLoop(t *testing.T, l net.Listener, cert tls.Certificate) {
+	t.Helper()
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				tlsConn := tls.Server(conn, &tls.Config{Certificates: []tls.Certificate{cert}})
+				if tlsConn.Handshake() == nil {
+					tlsConn.Read(make([]byte, 1))
+				}
+			}()
+		}
+	}()
+}
+
+func TestProbeAllAddresses(t *testing.T) {
+	ca := newTestCA(t, "Test CA")
+	leaf := newTestLeaf(t, ca, "lb.example.test")
+	l, err := net.Listen("tcp4", ":0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	serveTLSLoop(t, l, leaf.tlsCertificate(ca))
+	_, port, _ := net.SplitHostPort(l.Addr().String())
+
+	resolver := newDNSStub(t, dnsZone{
+		"lb.example.test.": {dnsmessage.TypeA: {
+			&dnsmessage.AResource{A: [4]byte{127, 0, 0, 3}},
+			&dnsmessage.AResource{A: [4]byte{127, 0, 0, 1}},
+			&dnsmessage.AResource{A: [4]byte{127, 0, 0, 2}},
+		}},
+		"empty.example.test.": {},
+	})
+	opts := Options{AllAddresses: true, Resolver: resolver, TrustStore: testTrustStore(ca)}
+
+	resp := probe(t, "lb.example.test:"+port, opts)
+	if resp.Error != nil {
+		t.Fatalf("probe failed: %v", resp.Error)
+	}
+	if len(resp.Backends) != 3 {
+		t.Fatalf("got %d backends, want 3", len(resp.Backends))
+	}
+	for k, want := range []string{"127.0.0.1", "127.0.0.2", "127.0.0.3"} {
+		if b := resp.Backends[k]; b.Address != want || !b.Verified {
+			t.Errorf("backend %d: got %s verified %v (%v), want %s", k, b.Address, b.Verified, b.Error, want)
+		}
+	}
+
+	resp = probe(t, "empty.example.test:"+port, opts)
+	if resp.ErrorCode != ErrCodeDNSNotFound {
+		t.Errorf("got error code %q (%v) for a name without address, want %q", resp.ErrorCode, resp.Error, ErrCodeDNSNotFound)
+	}
+}
+
+// TestProbeAllAddressesProxy checks a proxied hostname is probed once
+// through the proxy, and NO_PROXY matching the hostname probes every backend
+func TestProbeAllAddressesProxy(t *testing.T) {
+	ca := newTestCA(t, "Test CA")
+	leaf := newTestLeaf(t, ca, "lb.example.test")
+	l, err := net.Listen("tcp4", ":0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	serveTLSLoop(t, l, leaf.tlsCertificate(ca))
+	_, port, _ := net.SplitHostPort(l.Addr().String())
+	proxy := newConnectProxy(t, "127.0.0.1:"+port, "")
+	resolver := newDNSStub(t, dnsZone{
+		"lb.example.test.": {dnsmessage.TypeA: {
+			&dnsmessage.AResource{A: [4]byte{127, 0, 0, 1}},
+			&dnsmessage.AResource{A: [4]byte{127, 0, 0, 2}},
+		}},
+	})
+	opts := Options{AllAddresses: true, Resolver: resolver, Proxy: proxy.URL, TrustStore: testTrustStore(ca)}
+
+	t.Setenv("NO_PROXY", "")
+	resp := probe(t, "lb.example.test:"+port, opts)
+	if resp.Error != nil || len(resp.Backends) != 0 {
+		t.Fatalf("got %v with %d backends, want a single probe", resp.Error, len(resp.Backends))
+	}
+	if got := proxy.tunnels(); len(got) != 1 || got[0] != "lb.example.test:"+port {
+		t.Errorf("got tunnels %v, want the hostname", got)
+	}
+
+	t.Setenv("NO_PROXY", "lb.example.test")
+	resp = probe(t, "lb.example.test:"+port, opts)
+	if resp.Error != nil || len(resp.Backends) != 2 {
+		t.Fatalf("got %v with %d backends, want 2", resp.Error, len(resp.Backends))
+	}
+	if got := proxy.tunnels(); len(got) != 1 {
+		t.Errorf("got tunnels %v, want the backends probed directly", got)
+	}
+}
+
+// TestProbeAllAddressesRecords checks every backend is held to the CAA and
+// TLSA records of its hostname, in the CSV rows too
+func TestProbeAllAddressesRecords(t *testing.T) {
+	ca := newTestCA(t, "Let's Encrypt")
+	leaf := newTestLeaf(t, ca, "lb.example.test")
+	l, err := net.Listen("tcp4", ":0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	serveTLSLoop(t, l, leaf.tlsCertificate(ca))
+	_, port, _ := net.SplitHostPort(l.Addr().String())
+	resolver := newDNSStub(t, dnsZone{
+		"lb.example.test.": {
+			dnsmessage.TypeA: {
+				&dnsmessage.AResource{A: [4]byte{127, 0, 0, 1}},
+				&dnsmessage.AResource{A: [4]byte{127, 0, 0, 2}},
+			},
+			typeCAA: {caaRR(false, CAAIssue, "letsencrypt.org")},
+		},
+		"_" + port + "._tcp.lb.example.test.": {typeTLSA: {tlsaRR(TLSAUsageDANEEE, 1, 1, tlsaData(leaf.cert, 1, 1))}},
+	})
+	opts := Options{AllAddresses: true, CAA: true, DANE: true, Resolver: resolver, TrustStore: testTrustStore(ca)}
+
+	resp := probe(t, "lb.example.test:"+port, opts)
+	if resp.Error != nil || len(resp.Backends) != 2 {
+		t.Fatalf("got %v with %d backends, want 2", resp.Error, len(resp.Backends))
+	}
+	for _, b := range resp.Backends {
+		if b.CAAStatus() != CAAStatusAuthorized || b.DANE == nil || !b.DANE.Records[0].Matched {
+			t.Errorf("backend %s: got CAA %q and TLSA %+v", b.Address, b.CAAStatus(), b.DANE)
+		}
+	}
+
+	var buf bytes.Buffer
+	if err := (csvReport{}).Write(&buf, []Response{resp}, []string{"test"}); err != nil {
+		t.Fatal(err)
+	}
+	rows, err := csv.NewReader(&buf).ReadAll()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(rows) != 3 {
+		t.Fatalf("got %d rows, want a header and 2 backends", len(rows))
+	}
+	column := map[string]int{}
+	for k, name := range rows[0] {
+		column[name] = k
+	}
+	for _, row := range rows[1:] {
+		if row[column["tlsa"]] != "3 1 1 matched" || row[column["caa"]] != CAAStatusAuthorized {
+			t.Errorf("row of %s: got tlsa %q caa %q", row[column["address"]], row[column["tlsa"]], row[column["caa"]])
+		}
+	}
+}
@@ -0,0 +1,205 @@
+package domains
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"io"
+	"net"
+	"syscall"
+	"time"
+)
+
+var (
+	// ErrCancelled marks probes stopped because the run was cancelled
+	ErrCancelled = errors.New("cancelled")
+	// ErrInvalidTarget marks query entries that can't be parsed
+	ErrInvalidTarget = errors.New("invalid target")
+	// ErrStartTLS marks failures of the protocol upgrade before the handshake
+	ErrStartTLS = errors.New("starttls failed")
+	// ErrProxy marks failures to open a tunnel through the proxy
+	ErrProxy = errors.New("proxy failed")
+)
+
+// ErrorCode is a stable classification of probe failures
+type ErrorCode string
+
+const (
+	ErrCodeNone             ErrorCode = ""
+	ErrCodeInvalidTarget    ErrorCode = "invalid_target"
+	ErrCodeCancelled        ErrorCode = "cancelled"
+	ErrCodeTimeout          ErrorCode = "timeout"
+	ErrCodeDNSNotFound      ErrorCode = "dns_not_found"
+	ErrCodeDNSFailure       ErrorCode = "dns_failure"
+	ErrCodeRefused          ErrorCode = "connection_refused"
+	ErrCodeReset            ErrorCode = "connection_reset"
+	ErrCodeClosed           ErrorCode = "connection_closed"
+	ErrCodeUnreachable      ErrorCode = "network_unreachable"
+	ErrCodeStartTLS         ErrorCode = "starttls_failed"
+	ErrCodeProxy            ErrorCode = "proxy_failed"
+	ErrCodeExpired          ErrorCode = "certificate_expired"
+	ErrCodeNotYetValid      ErrorCode = "certificate_not_yet_valid"
+	ErrCodeHostnameMismatch ErrorCode = "hostname_mismatch"
+	ErrCodeUnknownAuthority ErrorCode = "unknown_authority"
+	ErrCodeSelfSigned       ErrorCode = "self_signed"
+	ErrCodeCertInvalid      ErrorCode = "certificate_invalid"
+	ErrCodeHandshake        ErrorCode = "handshake_failure"
+	ErrCodeClientCert       ErrorCode = "client_certificate"
+	ErrCodeTLSAlert         ErrorCode = "tls_alert"
+	ErrCodeNotTLS           ErrorCode = "not_tls"
+	ErrCodeUnknown          ErrorCode = "unknown"
+)
+
+var errorDescriptions = map[ErrorCode]string{
+	ErrCodeInvalidTarget:    "invalid target",
+	ErrCodeCancelled:        "cancelled",
+	ErrCodeTimeout:          "connection timeout",
+	ErrCodeDNSNotFound:      "no DNS entry for this host",
+	ErrCodeDNSFailure:       "DNS resolution failure",
+	ErrCodeRefused:          "connection refused",
+	ErrCodeReset:            "connection reset by peer",
+	ErrCodeClosed:           "connection closed during handshake",
+	ErrCodeUnreachable:      "network unreachable",
+	ErrCodeStartTLS:         "STARTTLS upgrade failed",
+	ErrCodeProxy:            "proxy failure",
+	ErrCodeExpired:          "certificate expired",
+	ErrCodeNotYetValid:      "certificate not yet valid",
+	ErrCodeHostnameMismatch: "certificate SAN don't include domain",
+	ErrCodeUnknownAuthority: "certificate signed by unknown authority",
+	ErrCodeSelfSigned:       "self-signed certificate",
+	ErrCodeCertInvalid:      "invalid certificate",
+	ErrCodeHandshake:        "TLS handshake failure",
+	ErrCodeClientCert:       "client certificate required or rejected",
+	ErrCodeTLSAlert:         "TLS alert from server",
+	ErrCodeNotTLS:           "server does not speak TLS",
+}
+
+// Description returns a short human readable form of the code
+func (c ErrorCode) Description() string {
+	if d, ok := errorDescriptions[c]; ok {
+		return d
+	}
+	return string(c)
+}
+
+// Transient tells whether failures of this class may go away on retry
+func (c ErrorCode) Transient() bool {
+	switch c {
+	case ErrCodeTimeout, ErrCodeDNSFailure, ErrCodeReset, ErrCodeClosed:
+		return true
+	}
+	return false
+}
+
+// Certificate tells whether the failure is about the certificate itself
+// rather than reaching the endpoint
+func (c ErrorCode) Certificate() bool {
+	switch c {
+	case ErrCodeExpired, ErrCodeNotYetValid, ErrCodeHostnameMismatch,
+		ErrCodeUnknownAuthority, ErrCodeSelfSigned, ErrCodeCertInvalid:
+		return true
+	}
+	return false
+}
+
+// TLS alerts from RFC 8446
+const (
+	tlsAlertHandshakeFailure    = 40
+	tlsAlertBadCertificate      = 42
+	tlsAlertCertificateRequired = 116
+)
+
+// Classify derives the error code of a probe failure from the error types
+// returned by the net, x509 and tls packages
+func Classify(err error) ErrorCode {
+	if err == nil {
+		return ErrCodeNone
+	}
+
+	var (
+		dnsErr       *net.DNSError
+		invalidErr   x509.CertificateInvalidError
+		authorityErr x509.UnknownAuthorityError
+		verifyErr    *tls.CertificateVerificationError
+		hostnameErr  x509.HostnameError
+		alertErr     tls.AlertError
+		recordErr    tls.RecordHeaderError
+		opErr        *net.OpError
+		netErr       net.Error
+	)
+	switch {
+	case errors.Is(err, ErrCancelled):
+		return ErrCodeCancelled
+	case errors.Is(err, ErrInvalidTarget):
+		return ErrCodeInvalidTarget
+	case errors.Is(err, ErrProxy):
+		return ErrCodeProxy
+	// A peer hanging up or stalling during the upgrade is a STARTTLS failure,
+	// not a transient connection error
+	case errors.Is(err, ErrStartTLS):
+		return ErrCodeStartTLS
+	case errors.As(err, &dnsErr):
+		if dnsErr.IsNotFound {
+			return ErrCodeDNSNotFound
+		}
+		if dnsErr.IsTimeout {
+			return ErrCodeTimeout
+		}
+		return ErrCodeDNSFailure
+	case errors.As(err, &hostnameErr):
+		return ErrCodeHostnameMismatch
+	case errors.As(err, &invalidErr):
+		if invalidErr.Reason == x509.Expired {
+			if invalidErr.Cert != nil && time.Now().Before(invalidErr.Cert.NotBefore) {
+				return ErrCodeNotYetValid
+			}
+			return ErrCodeExpired
+		}
+		return ErrCodeCertInvalid
+	case errors.As(err, &authorityErr):
+		// The error names the top of the chain, an untrusted root sent along
+		// with the leaf included, so the leaf is checked when known
+		c := authorityErr.Cert
+		if errors.As(err, &verifyErr) && len(verifyErr.UnverifiedCertificates) > 0 {
+			c = verifyErr.UnverifiedCertificates[0]
+		}
+		// Self-signed leaves seldom are CAs, CheckSignatureFrom would refuse them
+		if c != nil && bytes.Equal(c.RawIssuer, c.RawSubject) && c.CheckSignature(c.SignatureAlgorithm, c.RawTBSCertificate, c.Signature) == nil {
+			return ErrCodeSelfSigned
+		}
+		return ErrCodeUnknownAuthority
+	case errors.As(err, &alertErr):
+		switch alertErr {
+		case tlsAlertHandshakeFailure:
+			return ErrCodeHandshake
+		case tlsAlertBadCertificate, tlsAlertCertificateRequired:
+			// We only send certificates as a client
+			return ErrCodeClientCert
+		}
+		return ErrCodeTLSAlert
+	case errors.As(err, &opErr) && opErr.Op == "remote error":
+		// Alerts received from the peer have an unexported type, only their
+		// message tells them apart
+		switch opErr.Err.Error() {
+		case "tls: handshake failure":
+			return ErrCodeHandshake
+		case "tls: bad certificate", "tls: certificate required":
+			return ErrCodeClientCert
+		}
+		return ErrCodeTLSAlert
+	case errors.As(err, &recordErr):
+		return ErrCodeNotTLS
+	case errors.Is(err, syscall.ECONNREFUSED):
+		return ErrCodeRefused
+	case errors.Is(err, syscall.ECONNRESET), errors.Is(err, syscall.ECONNABORTED), errors.Is(err, syscall.EPIPE):
+		return ErrCodeReset
+	case errors.Is(err, syscall.ENETUNREACH), errors.Is(err, syscall.EHOSTUNREACH):
+		return ErrCodeUnreachable
+	case errors.As(err, &netErr) && netErr.Timeout():
+		return ErrCodeTimeout
+	case errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF):
+		return ErrCodeClosed
+	}
+	return ErrCodeUnknown
+}
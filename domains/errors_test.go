@@ -0,0 +1,188 @@
+package domains
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"testing"
+	"time"
+)
+
+// handshakeError returns the error of a client handshake with cfg, or of
+// the first read after it, against a server running serve on the accepted
+// connection
+func handshakeError(t *testing.T, cfg *tls.Config, serve func(net.Conn)) error {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		conn.SetDeadline(time.Now().Add(5 * time.Second))
+		serve(conn)
+	}()
+	conn, err := net.Dial("tcp", l.Addr().String())
+	if err != nil {
+		return err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(time.Second))
+	tlsConn := tls.Client(conn, cfg)
+	if err := tlsConn.Handshake(); err != nil {
+		return err
+	}
+	// TLS 1.3 servers reject client certificates after the client is done
+	_, err = tlsConn.Read(make([]byte, 1))
+	return err
+}
+
+// serveHandshake completes the server side of a handshake with cfg
+func serveHandshake(cfg *tls.Config) func(net.Conn) {
+	return func(conn net.Conn) {
+		tlsConn := tls.Server(conn, cfg)
+		tlsConn.Handshake()
+		// Let the client read the alerts before the connection goes away
+		tlsConn.Read(make([]byte, 1))
+	}
+}
+
+func TestClassify(t *testing.T) {
+	ca := newTestCA(t, "Test CA")
+	leaf := newTestLeaf(t, ca, "www.example.test")
+	expired := newTestCert(t, &x509.Certificate{
+		Subject:   pkix.Name{CommonName: "www.example.test"},
+		DNSNames:  []string{"www.example.test"},
+		NotBefore: time.Now().Add(-48 * time.Hour),
+		NotAfter:  time.Now().Add(-24 * time.Hour),
+	}, ca)
+	notYet := newTestCert(t, &x509.Certificate{
+		Subject:   pkix.Name{CommonName: "www.example.test"},
+		DNSNames:  []string{"www.example.test"},
+		NotBefore: time.Now().Add(24 * time.Hour),
+		NotAfter:  time.Now().Add(48 * time.Hour),
+	}, ca)
+	self := newTestCert(t, &x509.Certificate{
+		Subject:  pkix.Name{CommonName: "www.example.test"},
+		DNSNames: []string{"www.example.test"},
+	}, nil)
+	verify := func(c *testCert, name string) error {
+		_, err := verifyChain([]*x509.Certificate{c.cert}, name, testTrustStore(ca).Pool)
+		return err
+	}
+	serverCfg := &tls.Config{Certificates: []tls.Certificate{leaf.tlsCertificate(ca)}}
+	clientCfg := &tls.Config{ServerName: "www.example.test", RootCAs: testTrustStore(ca).Pool}
+
+	closed, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	closedAddr := closed.Addr().String()
+	closed.Close()
+
+	tests := []struct {
+		name string
+		err  error
+		want ErrorCode
+	}{
+		{"nil", nil, ErrCodeNone},
+		{"cancelled", fmt.Errorf("%w: run interrupted", ErrCancelled), ErrCodeCancelled},
+		{"invalid target", fmt.Errorf("%w: empty", ErrInvalidTarget), ErrCodeInvalidTarget},
+		{"proxy", fmt.Errorf("%w: refused", ErrProxy), ErrCodeProxy},
+		{"dns not found", &net.DNSError{Err: "no such host", Name: "nx.example.test", IsNotFound: true}, ErrCodeDNSNotFound},
+		{"dns timeout", &net.DNSError{Err: "i/o timeout", Name: "example.test", IsTimeout: true}, ErrCodeTimeout},
+		{"dns failure", &net.DNSError{Err: "server misbehaving", Name: "example.test"}, ErrCodeDNSFailure},
+		{"refused", func() error { _, err := net.Dial("tcp", closedAddr); return err }(), ErrCodeRefused},
+		{"reset", handshakeError(t, clientCfg, func(conn net.Conn) {
+			conn.(*net.TCPConn).SetLinger(0)
+		}), ErrCodeReset},
+		{"timeout", handshakeError(t, clientCfg, func(conn net.Conn) {
+			io.ReadAll(conn)
+		}), ErrCodeTimeout},
+		{"closed", handshakeError(t, clientCfg, func(conn net.Conn) {
+			// Reading the whole ClientHello closes without a reset
+			conn.Read(make([]byte, 1<<16))
+		}), ErrCodeClosed},
+		{"starttls closed", fmt.Errorf("%w: %w", ErrStartTLS, io.EOF), ErrCodeStartTLS},
+		{"starttls timeout", fmt.Errorf("%w: %w", ErrStartTLS, &net.OpError{Op: "read", Err: timeoutError{}}), ErrCodeStartTLS},
+		{"expired", verify(expired, "www.example.test"), ErrCodeExpired},
+		{"not yet valid", verify(notYet, "www.example.test"), ErrCodeNotYetValid},
+		{"hostname mismatch", verify(leaf, "other.example.test"), ErrCodeHostnameMismatch},
+		{"unknown authority", verify(newTestLeaf(t, newTestCA(t, "Other CA"), "www.example.test"), "www.example.test"), ErrCodeUnknownAuthority},
+		{"self-signed", verify(self, "www.example.test"), ErrCodeSelfSigned},
+		{"unknown authority in handshake", handshakeError(t, &tls.Config{ServerName: "www.example.test", RootCAs: x509.NewCertPool()}, serveHandshake(serverCfg)), ErrCodeUnknownAuthority},
+		{"remote handshake failure", handshakeError(t, &tls.Config{
+			ServerName:   "www.example.test",
+			RootCAs:      clientCfg.RootCAs,
+			MaxVersion:   tls.VersionTLS12,
+			CipherSuites: []uint16{tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256},
+		}, serveHandshake(&tls.Config{
+			Certificates: serverCfg.Certificates,
+			CipherSuites: []uint16{tls.TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305_SHA256},
+		})), ErrCodeHandshake},
+		{"remote certificate required", handshakeError(t, clientCfg, serveHandshake(&tls.Config{
+			Certificates: serverCfg.Certificates,
+			ClientAuth:   tls.RequireAnyClientCert,
+		})), ErrCodeClientCert},
+		{"remote protocol version", handshakeError(t, &tls.Config{
+			ServerName: "www.example.test",
+			MinVersion: tls.VersionTLS13,
+		}, serveHandshake(&tls.Config{
+			Certificates: serverCfg.Certificates,
+			MaxVersion:   tls.VersionTLS12,
+		})), ErrCodeTLSAlert},
+		{"local alert", tls.AlertError(tlsAlertBadCertificate), ErrCodeClientCert},
+		{"not tls", handshakeError(t, clientCfg, func(conn net.Conn) {
+			io.WriteString(conn, "HTTP/1.1 400 Bad Request\r\n\r\n")
+		}), ErrCodeNotTLS},
+		{"unknown", fmt.Errorf("something else"), ErrCodeUnknown},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := Classify(tt.err); got != tt.want {
+				t.Errorf("Classify(%v) = %s, want %s", tt.err, got, tt.want)
+			}
+		})
+	}
+}
+
+// TestClassifyRemoteAlertMessages pins the messages of the alerts received
+// from the peer, which Classify has to match as strings. The tls package
+// words them like its exported AlertError.
+func TestClassifyRemoteAlertMessages(t *testing.T) {
+	tests := []struct {
+		alert   uint8
+		message string
+		want    ErrorCode
+	}{
+		{tlsAlertHandshakeFailure, "tls: handshake failure", ErrCodeHandshake},
+		{tlsAlertBadCertificate, "tls: bad certificate", ErrCodeClientCert},
+		{tlsAlertCertificateRequired, "tls: certificate required", ErrCodeClientCert},
+		{70, "tls: protocol version not supported", ErrCodeTLSAlert},
+	}
+	for _, tt := range tests {
+		if got := tls.AlertError(tt.alert).Error(); got != tt.message {
+			t.Errorf("alert %d: got message %q, want %q", tt.alert, got, tt.message)
+		}
+		err := &net.OpError{Op: "remote error", Err: errors.New(tt.message)}
+		if got := Classify(err); got != tt.want {
+			t.Errorf("alert %d (%v): got %s, want %s", tt.alert, err, got, tt.want)
+		}
+	}
+}
+
+// timeoutError is a net.Error timing out
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
@@ -0,0 +1,173 @@
+package domains
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/net/http2"
+)
+
+// maxHTTPBody bounds the body read when falling back to GET
+const maxHTTPBody = 1 << 20
+
+// HSTS is a parsed Strict-Transport-Security header
+type HSTS struct {
+	MaxAge            time.Duration
+	IncludeSubDomains bool
+	Preload           bool
+	Raw               string
+}
+
+// HTTPResult is the answer to the request sent over the TLS connection
+type HTTPResult struct {
+	Method string
+	Proto  string
+	Status int
+	// HSTS is nil when the header is missing
+	HSTS     *HSTS
+	Location string
+	Server   string
+	Error    error
+}
+
+// Redirect tells whether the server answered with a redirection
+func (h *HTTPResult) Redirect() bool {
+	return h != nil && h.Status >= 300 && h.Status < 400 && h.Location != ""
+}
+
+// httpCheck sends a HEAD request for / over conn, using HTTP/2 when it was
+// negotiated, and a GET when HEAD is not allowed. When the server closed
+// conn, the GET goes over a connection from redial, opened once conn is
+// closed so it doesn't wait on the slot of conn.
+func httpCheck(ctx context.Context, conn *tls.Conn, redial func() (*tls.Conn, error), target Target, timeout time.Duration) *HTTPResult {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	conn.SetDeadline(time.Now().Add(timeout))
+
+	host := target.Host
+	if target.Port != protocols[DefaultProtocol].port {
+		host = net.JoinHostPort(target.Host, target.Port)
+	}
+
+	roundTrip, err := httpRoundTripper(conn)
+	if err != nil {
+		return &HTTPResult{Error: err}
+	}
+	redialed := false
+	reconnect := func() error {
+		conn.Close()
+		c, err := redial()
+		if err != nil {
+			return err
+		}
+		conn, redialed = c, true
+		roundTrip, err = httpRoundTripper(conn)
+		return err
+	}
+	defer func() {
+		if redialed {
+			conn.Close()
+		}
+	}()
+
+	var result *HTTPResult
+	for _, method := range []string{http.MethodHead, http.MethodGet} {
+		req, err := http.NewRequestWithContext(ctx, method, "https://"+host+"/", nil)
+		if err != nil {
+			return &HTTPResult{Method: method, Error: err}
+		}
+		req.Header.Set("User-Agent", "ssl-checker")
+		resp, err := roundTrip(req)
+		// A server closing the connection without saying so is only seen
+		// once the GET fails on it
+		if err != nil && method == http.MethodGet && !redialed && redial != nil {
+			if err = reconnect(); err == nil {
+				resp, err = roundTrip(req)
+			}
+		}
+		if err != nil {
+			return &HTTPResult{Method: method, Error: err}
+		}
+		// Drain the body so the connection can carry the GET
+		io.Copy(io.Discard, io.LimitReader(resp.Body, maxHTTPBody))
+		resp.Body.Close()
+
+		result = &HTTPResult{
+			Method:   method,
+			Proto:    resp.Proto,
+			Status:   resp.StatusCode,
+			Location: resp.Header.Get("Location"),
+			Server:   resp.Header.Get("Server"),
+		}
+		if raw := resp.Header.Get("Strict-Transport-Security"); raw != "" {
+			result.HSTS = parseHSTS(raw)
+		}
+		if resp.StatusCode != http.StatusMethodNotAllowed && resp.StatusCode != http.StatusNotImplemented {
+			break
+		}
+		if resp.Close && redial != nil {
+			if err := reconnect(); err != nil {
+				return &HTTPResult{Method: http.MethodGet, Error: err}
+			}
+		}
+	}
+	return result
+}
+
+// httpRoundTripper returns a function sending requests over conn, with
+// HTTP/2 when it was negotiated
+func httpRoundTripper(conn *tls.Conn) (func(*http.Request) (*http.Response, error), error) {
+	if conn.ConnectionState().NegotiatedProtocol == http2.NextProtoTLS {
+		cc, err := (&http2.Transport{}).NewClientConn(conn)
+		if err != nil {
+			return nil, err
+		}
+		return cc.RoundTrip, nil
+	}
+	reader := bufio.NewReader(conn)
+	return func(req *http.Request) (*http.Response, error) {
+		if err := req.Write(conn); err != nil {
+			return nil, err
+		}
+		return http.ReadResponse(reader, req)
+	}, nil
+}
+
+// parseHSTS reads the directives of a Strict-Transport-Security header
+// (RFC 6797), unknown ones are ignored
+func parseHSTS(raw string) *HSTS {
+	h := &HSTS{Raw: raw}
+	for _, directive := range strings.Split(raw, ";") {
+		name, value, _ := strings.Cut(strings.TrimSpace(directive), "=")
+		switch strings.ToLower(strings.TrimSpace(name)) {
+		case "max-age":
+			if seconds, err := strconv.ParseInt(strings.Trim(strings.TrimSpace(value), `"`), 10, 64); err == nil {
+				h.MaxAge = time.Duration(seconds) * time.Second
+			}
+		case "includesubdomains":
+			h.IncludeSubDomains = true
+		case "preload":
+			h.Preload = true
+		}
+	}
+	return h
+}
+
+func (h *HSTS) String() string {
+	s := fmt.Sprintf("max-age %d days", int(h.MaxAge.Hours()/24))
+	if h.IncludeSubDomains {
+		s += ", includeSubDomains"
+	}
+	if h.Preload {
+		s += ", preload"
+	}
+	return s
+}
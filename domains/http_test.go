@@ -0,0 +1,165 @@
+package domains
+
+import (
+	"context"
+	"crypto/tls"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// newHTTPServer starts a TLS server for handler, offering HTTP/2 when h2
+func newHTTPServer(t *testing.T, h2 bool, handler http.HandlerFunc) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewUnstartedServer(handler)
+	srv.EnableHTTP2 = h2
+	srv.StartTLS()
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+// dialHTTP opens a TLS connection to srv negotiating h2 or http/1.1
+func dialHTTP(t *testing.T, srv *httptest.Server, h2 bool) *tls.Conn {
+	t.Helper()
+	protos := []string{"http/1.1"}
+	if h2 {
+		protos = []string{"h2", "http/1.1"}
+	}
+	conn, err := tls.Dial("tcp", srv.Listener.Addr().String(), &tls.Config{InsecureSkipVerify: true, NextProtos: protos})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return conn
+}
+
+var httpTarget = Target{Host: "www.example.test", Port: "443", Protocol: DefaultProtocol}
+
+func TestHTTPCheck(t *testing.T) {
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		if r.Host != httpTarget.Host || r.URL.Path != "/" {
+			http.Error(w, "unexpected request", http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Strict-Transport-Security", "max-age=31536000; includeSubDomains; preload")
+		w.Header().Set("Server", "test")
+		http.Redirect(w, r, "https://example.test/home", http.StatusMovedPermanently)
+	}
+	for _, h2 := range []bool{false, true} {
+		proto := "HTTP/1.1"
+		if h2 {
+			proto = "HTTP/2.0"
+		}
+		t.Run(proto, func(t *testing.T) {
+			srv := newHTTPServer(t, h2, handler)
+			conn := dialHTTP(t, srv, h2)
+			defer conn.Close()
+
+			r := httpCheck(context.Background(), conn, nil, httpTarget, 5*time.Second)
+			if r.Error != nil {
+				t.Fatal(r.Error)
+			}
+			if r.Proto != proto || r.Method != http.MethodHead || r.Status != http.StatusMovedPermanently || r.Server != "test" {
+				t.Errorf("got %s %s %d from %s", r.Proto, r.Method, r.Status, r.Server)
+			}
+			if !r.Redirect() || r.Location != "https://example.test/home" {
+				t.Errorf("got redirect %v to %s", r.Redirect(), r.Location)
+			}
+			if r.HSTS == nil || r.HSTS.MaxAge != 365*24*time.Hour || !r.HSTS.IncludeSubDomains || !r.HSTS.Preload {
+				t.Errorf("got HSTS %+v", r.HSTS)
+			}
+		})
+	}
+}
+
+func TestHTTPCheckGetFallback(t *testing.T) {
+	handler := func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodHead {
+			w.WriteHeader(http.StatusMethodNotAllowed)
+			return
+		}
+		io.WriteString(w, "ok")
+	}
+	for _, h2 := range []bool{false, true} {
+		srv := newHTTPServer(t, h2, handler)
+		conn := dialHTTP(t, srv, h2)
+		r := httpCheck(context.Background(), conn, nil, httpTarget, 5*time.Second)
+		conn.Close()
+		if r.Error != nil || r.Method != http.MethodGet || r.Status != http.StatusOK || r.HSTS != nil {
+			t.Errorf("h2 %v: got %+v, want a GET answered 200 without HSTS", h2, r)
+		}
+	}
+}
+
+// TestHTTPCheckGetFallbackClosed checks the GET goes over a new connection
+// when the server closes the first one after refusing HEAD, saying so or not
+func TestHTTPCheckGetFallbackClosed(t *testing.T) {
+	tests := []struct {
+		name  string
+		close func(w http.ResponseWriter)
+	}{
+		{"connection close", func(w http.ResponseWriter) {
+			w.Header().Set("Connection", "close")
+			w.WriteHeader(http.StatusMethodNotAllowed)
+		}},
+		{"silent", func(w http.ResponseWriter) {
+			conn, buf, err := http.NewResponseController(w).Hijack()
+			if err != nil {
+				return
+			}
+			buf.WriteString("HTTP/1.1 405 Method Not Allowed\r\nContent-Length: 0\r\n\r\n")
+			buf.Flush()
+			conn.Close()
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := newHTTPServer(t, false, func(w http.ResponseWriter, r *http.Request) {
+				if r.Method == http.MethodHead {
+					tt.close(w)
+					return
+				}
+				w.Header().Set("Strict-Transport-Security", "max-age=600")
+				io.WriteString(w, "ok")
+			})
+			var redials atomic.Int32
+			redial := func() (*tls.Conn, error) {
+				redials.Add(1)
+				return dialHTTP(t, srv, false), nil
+			}
+			conn := dialHTTP(t, srv, false)
+			defer conn.Close()
+
+			r := httpCheck(context.Background(), conn, redial, httpTarget, 5*time.Second)
+			if r.Error != nil || r.Method != http.MethodGet || r.Status != http.StatusOK {
+				t.Fatalf("got %+v, want a GET answered 200", r)
+			}
+			if r.HSTS == nil || r.HSTS.MaxAge != 10*time.Minute {
+				t.Errorf("got HSTS %+v", r.HSTS)
+			}
+			if redials.Load() != 1 {
+				t.Errorf("got %d redials, want 1", redials.Load())
+			}
+		})
+	}
+}
+
+func TestParseHSTS(t *testing.T) {
+	tests := []struct {
+		raw  string
+		want HSTS
+	}{
+		{"max-age=31536000", HSTS{MaxAge: 365 * 24 * time.Hour}},
+		{`Max-Age="600"; IncludeSubDomains`, HSTS{MaxAge: 10 * time.Minute, IncludeSubDomains: true}},
+		{"max-age=0;preload", HSTS{Preload: true}},
+		{"includeSubDomains; max-age=bogus; unknown=1", HSTS{IncludeSubDomains: true}},
+	}
+	for _, tt := range tests {
+		tt.want.Raw = tt.raw
+		if got := parseHSTS(tt.raw); *got != tt.want {
+			t.Errorf("parseHSTS(%q) = %+v, want %+v", tt.raw, *got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,181 @@
+package domains
+
+import (
+	"bytes"
+	"context"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"time"
+
+	"golang.org/x/crypto/ocsp"
+)
+
+// Revocation statuses of a certificate
+const (
+	RevocationGood    = "good"
+	RevocationRevoked = "revoked"
+	RevocationUnknown = "unknown"
+)
+
+// maxOCSPResponse bounds the size of responder answers
+const maxOCSPResponse = 1 << 20
+
+// OCSPResult is a parsed OCSP response, either stapled by the server or
+// fetched from the responder of the certificate
+type OCSPResult struct {
+	// Status is good, revoked or unknown, empty when the response is unusable
+	Status     string
+	ThisUpdate time.Time
+	NextUpdate time.Time
+	RevokedAt  time.Time
+	// Responder is the responder URL, or the name or key hash the response
+	// was signed by when stapled
+	Responder string
+	Error     error
+}
+
+// Revocation returns the revocation status of the chain: revoked when any
+// OCSP response or CRL says so, otherwise the status from the OCSP
+// responder when it was queried, the stapled response or the CRLs
+func (i Response) Revocation() string {
+	if i.Revoked() {
+		return RevocationRevoked
+	}
+	for _, r := range []*OCSPResult{i.OCSPResponder, i.OCSPStapled} {
+		if r != nil && r.Status != "" {
+			return r.Status
+		}
+	}
+	for _, r := range i.CRL {
+		if r.Status != "" {
+			return r.Status
+		}
+	}
+	return ""
+}
+
+// Revoked tells whether an OCSP response or a CRL reported a certificate
+// of the chain revoked
+func (i Response) Revoked() bool {
+	for _, r := range []*OCSPResult{i.OCSPResponder, i.OCSPStapled} {
+		if r != nil && r.Status == RevocationRevoked {
+			return true
+		}
+	}
+	for _, r := range i.CRL {
+		if r.Status == RevocationRevoked {
+			return true
+		}
+	}
+	return false
+}
+
+// StaleCRL tells whether a CRL used was past its nextUpdate
+func (i Response) StaleCRL() bool {
+	for _, r := range i.CRL {
+		if r.Stale {
+			return true
+		}
+	}
+	return false
+}
+
+// issuerOf returns the certificate following the leaf in chain, nil when
+// the chain only holds the leaf
+func issuerOf(chain []*x509.Certificate) *x509.Certificate {
+	if len(chain) < 2 {
+		return nil
+	}
+	return chain[1]
+}
+
+// parseOCSP reads a DER OCSP response for leaf, its signature is checked
+// when the issuer is known
+func parseOCSP(raw []byte, leaf, issuer *x509.Certificate) *OCSPResult {
+	var (
+		resp *ocsp.Response
+		err  error
+	)
+	if issuer != nil {
+		resp, err = ocsp.ParseResponseForCert(raw, leaf, issuer)
+	} else {
+		resp, err = ocsp.ParseResponse(raw, nil)
+	}
+	if err != nil {
+		return &OCSPResult{Error: fmt.Errorf("invalid OCSP response: %w", err)}
+	}
+	if resp.SerialNumber.Cmp(leaf.SerialNumber) != 0 {
+		return &OCSPResult{Error: errors.New("invalid OCSP response: serial number mismatch")}
+	}
+
+	r := &OCSPResult{
+		ThisUpdate: resp.ThisUpdate,
+		NextUpdate: resp.NextUpdate,
+		RevokedAt:  resp.RevokedAt,
+	}
+	switch resp.Status {
+	case ocsp.Good:
+		r.Status = RevocationGood
+	case ocsp.Revoked:
+		r.Status = RevocationRevoked
+	default:
+		r.Status = RevocationUnknown
+	}
+	var rdn pkix.RDNSequence
+	if _, err := asn1.Unmarshal(resp.RawResponderName, &rdn); err == nil && len(rdn) > 0 {
+		r.Responder = rdn.String()
+	} else if len(resp.ResponderKeyHash) > 0 {
+		r.Responder = "key " + hex.EncodeToString(resp.ResponderKeyHash)
+	}
+	if !r.NextUpdate.IsZero() && time.Now().After(r.NextUpdate) {
+		r.Error = fmt.Errorf("stale OCSP response, next update was %s", r.NextUpdate.Format("2006-01-02 15:04"))
+	}
+	return r
+}
+
+// queryOCSP asks the first OCSP responder listed in the leaf AIA extension
+// for its status, with client
+func queryOCSP(ctx context.Context, client *http.Client, leaf, issuer *x509.Certificate, timeout time.Duration) *OCSPResult {
+	if len(leaf.OCSPServer) == 0 {
+		return nil
+	}
+	url := leaf.OCSPServer[0]
+	if issuer == nil {
+		return &OCSPResult{Responder: url, Error: errors.New("issuer certificate unknown, can't build OCSP request")}
+	}
+	req, err := ocsp.CreateRequest(leaf, issuer, nil)
+	if err != nil {
+		return &OCSPResult{Responder: url, Error: err}
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(req))
+	if err != nil {
+		return &OCSPResult{Responder: url, Error: err}
+	}
+	httpReq.Header.Set("Content-Type", "application/ocsp-request")
+	httpReq.Header.Set("Accept", "application/ocsp-response")
+	httpResp, err := client.Do(httpReq)
+	if err != nil {
+		return &OCSPResult{Responder: url, Error: err}
+	}
+	defer httpResp.Body.Close()
+	if httpResp.StatusCode != http.StatusOK {
+		return &OCSPResult{Responder: url, Error: fmt.Errorf("OCSP responder returned %s", httpResp.Status)}
+	}
+	raw, err := io.ReadAll(io.LimitReader(httpResp.Body, maxOCSPResponse))
+	if err != nil {
+		return &OCSPResult{Responder: url, Error: err}
+	}
+
+	r := parseOCSP(raw, leaf, issuer)
+	r.Responder = url
+	return r
+}
@@ -0,0 +1,256 @@
+package domains
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"io"
+	"math/big"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/ocsp"
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// ocspResponder stands in for the OCSP responder of a CA, answering with
+// the status set for each serial number
+type ocspResponder struct {
+	*httptest.Server
+	ca *testCert
+	// signer signs the responses, the CA key unless replaced
+	signer crypto.Signer
+
+	mu     sync.Mutex
+	status map[string]int
+}
+
+func newOCSPResponder(t *testing.T, ca *testCert) *ocspResponder {
+	t.Helper()
+	r := &ocspResponder{ca: ca, signer: ca.key, status: map[string]int{}}
+	r.Server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		body, err := io.ReadAll(req.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		ocspReq, err := ocsp.ParseRequest(body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "application/ocsp-response")
+		w.Write(r.response(t, ocspReq.SerialNumber))
+	}))
+	t.Cleanup(r.Close)
+	return r
+}
+
+// set changes the status returned for serial
+func (r *ocspResponder) set(serial *big.Int, status int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.status[serial.String()] = status
+}
+
+// response returns the DER response for serial, unknown when unset
+func (r *ocspResponder) response(t *testing.T, serial *big.Int) []byte {
+	r.mu.Lock()
+	status, ok := r.status[serial.String()]
+	r.mu.Unlock()
+	if !ok {
+		status = ocsp.Unknown
+	}
+	tmpl := ocsp.Response{
+		Status:       status,
+		SerialNumber: serial,
+		ThisUpdate:   time.Now().Add(-time.Hour),
+		NextUpdate:   time.Now().Add(24 * time.Hour),
+	}
+	if status == ocsp.Revoked {
+		tmpl.RevokedAt = time.Now().Add(-2 * time.Hour)
+		tmpl.RevocationReason = ocsp.KeyCompromise
+	}
+	raw, err := ocsp.CreateResponse(r.ca.cert, r.ca.cert, tmpl, r.signer)
+	if err != nil {
+		t.Error(err)
+	}
+	return raw
+}
+
+// newOCSPLeaf returns a leaf pointing to the responder in its AIA
+func newOCSPLeaf(t *testing.T, ca *testCert, responder string) *testCert {
+	t.Helper()
+	return newTestCert(t, &x509.Certificate{
+		DNSNames:    []string{"ocsp.example.test"},
+		KeyUsage:    x509.KeyUsageDigitalSignature,
+		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		OCSPServer:  []string{responder},
+	}, ca)
+}
+
+func TestQueryOCSP(t *testing.T) {
+	ca := newTestCA(t, "Test CA")
+	responder := newOCSPResponder(t, ca)
+
+	tests := []struct {
+		name   string
+		status int
+		want   string
+	}{
+		{"good", ocsp.Good, RevocationGood},
+		{"revoked", ocsp.Revoked, RevocationRevoked},
+		{"unknown", ocsp.Unknown, RevocationUnknown},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			leaf := newOCSPLeaf(t, ca, responder.URL)
+			responder.set(leaf.cert.SerialNumber, tt.status)
+
+			r := queryOCSP(context.Background(), http.DefaultClient, leaf.cert, ca.cert, time.Second)
+			if r == nil || r.Error != nil {
+				t.Fatalf("got %+v", r)
+			}
+			if r.Status != tt.want || r.Responder != responder.URL {
+				t.Errorf("got status %s from %s, want %s from %s", r.Status, r.Responder, tt.want, responder.URL)
+			}
+			if tt.status == ocsp.Revoked && r.RevokedAt.IsZero() {
+				t.Error("revocation time missing")
+			}
+		})
+	}
+}
+
+func TestQueryOCSPBadSignature(t *testing.T) {
+	ca := newTestCA(t, "Test CA")
+	responder := newOCSPResponder(t, ca)
+	rogue, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	responder.signer = rogue
+	leaf := newOCSPLeaf(t, ca, responder.URL)
+	responder.set(leaf.cert.SerialNumber, ocsp.Good)
+
+	r := queryOCSP(context.Background(), http.DefaultClient, leaf.cert, ca.cert, time.Second)
+	if r == nil || r.Error == nil || r.Status != "" {
+		t.Fatalf("got %+v, want a signature error and no status", r)
+	}
+}
+
+func TestParseOCSPSerialMismatch(t *testing.T) {
+	ca := newTestCA(t, "Test CA")
+	responder := newOCSPResponder(t, ca)
+	leaf := newOCSPLeaf(t, ca, responder.URL)
+	other := newOCSPLeaf(t, ca, responder.URL)
+	responder.set(other.cert.SerialNumber, ocsp.Good)
+
+	if r := parseOCSP(responder.response(t, other.cert.SerialNumber), leaf.cert, ca.cert); r.Error == nil {
+		t.Errorf("got %+v, want a serial number error", r)
+	}
+}
+
+// TestOCSPStapledAndResponder checks both sources are recorded apart, and a
+// revocation from either one wins
+func TestOCSPStapledAndResponder(t *testing.T) {
+	ca := newTestCA(t, "Test CA")
+	responder := newOCSPResponder(t, ca)
+	leaf := newOCSPLeaf(t, ca, responder.URL)
+
+	// The staple was good when fetched, the responder now says revoked
+	responder.set(leaf.cert.SerialNumber, ocsp.Good)
+	cert := leaf.tlsCertificate(ca)
+	cert.OCSPStaple = responder.response(t, leaf.cert.SerialNumber)
+	responder.set(leaf.cert.SerialNumber, ocsp.Revoked)
+
+	addr, errc := serveTLS(t, nil, cert)
+	resp := probe(t, "ocsp.example.test?connect="+addr, Options{OCSP: true, TrustStore: testTrustStore(ca)})
+	if err := <-errc; err != nil {
+		t.Fatalf("server: %v", err)
+	}
+	if resp.OCSPStapled == nil || resp.OCSPStapled.Status != RevocationGood {
+		t.Errorf("got stapled %+v, want good", resp.OCSPStapled)
+	} else if resp.OCSPStapled.Responder != ca.cert.Subject.String() {
+		t.Errorf("got stapled responder %s, want %s", resp.OCSPStapled.Responder, ca.cert.Subject)
+	}
+	if resp.OCSPResponder == nil || resp.OCSPResponder.Status != RevocationRevoked {
+		t.Errorf("got responder %+v, want revoked", resp.OCSPResponder)
+	}
+	if !resp.Revoked() || resp.Revocation() != RevocationRevoked {
+		t.Errorf("got revocation %s, want revoked", resp.Revocation())
+	}
+}
+
+// TestRevocationThroughProxy checks OCSP and CRL requests go through the
+// proxy of the probes, the responder name only resolving behind it
+func TestRevocationThroughProxy(t *testing.T) {
+	ca := newTestCA(t, "Test CA")
+	responder := newOCSPResponder(t, ca)
+	proxy := newConnectProxy(t, responder.Listener.Addr().String(), "")
+	_, port, _ := net.SplitHostPort(responder.Listener.Addr().String())
+	url := "http://revocation.example.test:" + port
+	client := Options{Proxy: proxy.URL, Timeout: 5 * time.Second}.httpClient()
+
+	leaf := newOCSPLeaf(t, ca, url)
+	responder.set(leaf.cert.SerialNumber, ocsp.Good)
+	if r := queryOCSP(context.Background(), client, leaf.cert, ca.cert, 5*time.Second); r == nil || r.Status != RevocationGood {
+		t.Errorf("got OCSP %+v through the proxy, want good", r)
+	}
+
+	crlLeaf := newTestCert(t, &x509.Certificate{CRLDistributionPoints: []string{url + "/ca.crl"}}, ca)
+	cache, err := NewCRLCache(t.TempDir(), false, 5*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The responder answers the GET with an error, reaching it is enough
+	if r := cache.Check(context.Background(), client, []*x509.Certificate{crlLeaf.cert, ca.cert}); len(r) != 1 || r[0].Error == nil {
+		t.Fatalf("got CRL %+v", r)
+	}
+
+	want := []string{"revocation.example.test:" + port, "revocation.example.test:" + port}
+	if got := proxy.tunnels(); !reflect.DeepEqual(got, want) {
+		t.Errorf("got tunnels %v, want %v", got, want)
+	}
+}
+
+// TestRevocationSourceAndResolver checks OCSP and CRL requests resolve the
+// responder with the resolver of the probes and bind to their source
+func TestRevocationSourceAndResolver(t *testing.T) {
+	ca := newTestCA(t, "Test CA")
+	responder := newOCSPResponder(t, ca)
+	_, port, _ := net.SplitHostPort(responder.Listener.Addr().String())
+	url := "http://revocation.example.test:" + port
+	resolver := newDNSStub(t, dnsZone{
+		"revocation.example.test.": {dnsmessage.TypeA: {&dnsmessage.AResource{A: [4]byte{127, 0, 0, 1}}}},
+	})
+	opts := Options{Proxy: ProxyDirect, Resolver: resolver, Source: []net.IP{net.ParseIP("127.0.0.1")}, Timeout: 5 * time.Second}
+
+	leaf := newOCSPLeaf(t, ca, url)
+	responder.set(leaf.cert.SerialNumber, ocsp.Revoked)
+	if r := queryOCSP(context.Background(), opts.httpClient(), leaf.cert, ca.cert, 5*time.Second); r == nil || r.Status != RevocationRevoked {
+		t.Errorf("got OCSP %+v, want revoked", r)
+	}
+
+	// No IPv6 address of the responder matches the source
+	opts.Source = []net.IP{net.ParseIP("::1")}
+	if r := queryOCSP(context.Background(), opts.httpClient(), leaf.cert, ca.cert, 5*time.Second); r == nil || r.Error == nil || !strings.Contains(r.Error.Error(), "source address family") {
+		t.Errorf("got OCSP %+v, want a source address error", r)
+	}
+	crlLeaf := newTestCert(t, &x509.Certificate{CRLDistributionPoints: []string{url + "/ca.crl"}}, ca)
+	cache, err := NewCRLCache(t.TempDir(), false, 5*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r := cache.Check(context.Background(), opts.httpClient(), []*x509.Certificate{crlLeaf.cert, ca.cert}); len(r) != 1 || r[0].Error == nil || !strings.Contains(r[0].Error.Error(), "source address family") {
+		t.Errorf("got CRL %+v, want a source address error", r)
+	}
+}
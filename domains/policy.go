@@ -0,0 +1,276 @@
+package domains
+
+import (
+	"crypto/dsa"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/x509"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// CertInfo describes the key, signature and constraints of a certificate
+type CertInfo struct {
+	Subject            string
+	KeyAlgorithm       string
+	KeySize            int
+	Curve              string
+	SignatureAlgorithm string
+	Version            int
+	IsCA               bool
+	// BasicConstraints tells whether the extension is present
+	BasicConstraints bool
+	MaxPathLen       int
+	KeyUsage         []string
+	ExtKeyUsage      []string
+	Validity         time.Duration
+}
+
+var keyUsageNames = []struct {
+	usage x509.KeyUsage
+	name  string
+}{
+	{x509.KeyUsageDigitalSignature, "digitalSignature"},
+	{x509.KeyUsageContentCommitment, "contentCommitment"},
+	{x509.KeyUsageKeyEncipherment, "keyEncipherment"},
+	{x509.KeyUsageDataEncipherment, "dataEncipherment"},
+	{x509.KeyUsageKeyAgreement, "keyAgreement"},
+	{x509.KeyUsageCertSign, "keyCertSign"},
+	{x509.KeyUsageCRLSign, "cRLSign"},
+	{x509.KeyUsageEncipherOnly, "encipherOnly"},
+	{x509.KeyUsageDecipherOnly, "decipherOnly"},
+}
+
+var extKeyUsageNames = map[x509.ExtKeyUsage]string{
+	x509.ExtKeyUsageAny:             "any",
+	x509.ExtKeyUsageServerAuth:      "serverAuth",
+	x509.ExtKeyUsageClientAuth:      "clientAuth",
+	x509.ExtKeyUsageCodeSigning:     "codeSigning",
+	x509.ExtKeyUsageEmailProtection: "emailProtection",
+	x509.ExtKeyUsageTimeStamping:    "timeStamping",
+	x509.ExtKeyUsageOCSPSigning:     "OCSPSigning",
+}
+
+// NewCertInfo extracts the key, signature and constraints of c
+func NewCertInfo(c *x509.Certificate) CertInfo {
+	info := CertInfo{
+		Subject:            c.Subject.String(),
+		KeyAlgorithm:       c.PublicKeyAlgorithm.String(),
+		SignatureAlgorithm: c.SignatureAlgorithm.String(),
+		Version:            c.Version,
+		IsCA:               c.IsCA,
+		BasicConstraints:   c.BasicConstraintsValid,
+		MaxPathLen:         c.MaxPathLen,
+		Validity:           c.NotAfter.Sub(c.NotBefore),
+	}
+	switch key := c.PublicKey.(type) {
+	case *rsa.PublicKey:
+		info.KeySize = key.N.BitLen()
+	case *ecdsa.PublicKey:
+		info.KeySize = key.Curve.Params().BitSize
+		info.Curve = key.Curve.Params().Name
+	case ed25519.PublicKey:
+		info.KeySize = 256
+		info.Curve = "Ed25519"
+	case *dsa.PublicKey:
+		info.KeySize = key.P.BitLen()
+	}
+	for _, u := range keyUsageNames {
+		if c.KeyUsage&u.usage != 0 {
+			info.KeyUsage = append(info.KeyUsage, u.name)
+		}
+	}
+	for _, u := range c.ExtKeyUsage {
+		name, ok := extKeyUsageNames[u]
+		if !ok {
+			name = fmt.Sprintf("eku(%d)", u)
+		}
+		info.ExtKeyUsage = append(info.ExtKeyUsage, name)
+	}
+	for _, oid := range c.UnknownExtKeyUsage {
+		info.ExtKeyUsage = append(info.ExtKeyUsage, oid.String())
+	}
+	return info
+}
+
+// ChainInfo describes every certificate of the presented chain, leaf first
+func (i Response) ChainInfo() []CertInfo {
+	infos := make([]CertInfo, len(i.Chain))
+	for k, c := range i.Chain {
+		infos[k] = NewCertInfo(c)
+	}
+	return infos
+}
+
+// Finding severities
+const (
+	FindingWarning  = "warning"
+	FindingCritical = "critical"
+)
+
+// Finding is a policy violation of a certificate of the chain or of the
+// endpoint
+type Finding struct {
+	Severity string
+	// Subject is the certificate or endpoint the finding is about
+	Subject string
+	Message string
+}
+
+func (f Finding) String() string {
+	return fmt.Sprintf("%s: %s: %s", f.Severity, f.Subject, f.Message)
+}
+
+// Policy holds the requirements certificates are evaluated against
+type Policy struct {
+	MinRSABits   int
+	MinECDSABits int
+	// MaxValidity is the longest lifetime allowed for a leaf certificate
+	MaxValidity time.Duration
+	// MinSCTs is the number of SCTs a publicly trusted leaf needs, raised to
+	// MinSCTsLongLived for leaves valid more than 180 days
+	MinSCTs          int
+	MinSCTsLongLived int
+}
+
+// DefaultPolicy follows the CA/Browser Forum baseline requirements
+func DefaultPolicy() Policy {
+	return Policy{
+		MinRSABits:   2048,
+		MinECDSABits: 256,
+		MaxValidity:  398 * 24 * time.Hour,
+		// Chrome CT policy
+		MinSCTs:          2,
+		MinSCTsLongLived: 3,
+	}
+}
+
+// evaluate checks the presented chain, its SCTs, the CAA and TLSA records
+// and the HTTP answer of the endpoint against the default policy
+func (i Response) evaluate() []Finding {
+	p := DefaultPolicy()
+	findings := p.Evaluate(i.Chain)
+	// Only certificates chaining to the system roots have to be logged
+	if i.Verified && i.TrustStore == SystemTrustStore && len(i.Chain) > 0 {
+		leaf := i.Chain[0]
+		required := p.MinSCTs
+		if leaf.NotAfter.Sub(leaf.NotBefore) > 180*24*time.Hour {
+			required = p.MinSCTsLongLived
+		}
+		// Without a log list only a shortage can be told, unverified SCTs
+		// are reported apart and never count as valid
+		n, state := i.ValidSCTs(), "valid"
+		if unverified := i.UnverifiedSCTs(); unverified > 0 {
+			n, state = n+unverified, "unverified"
+		}
+		if n < required {
+			findings = append(findings, Finding{Severity: FindingWarning, Subject: leaf.Subject.String(), Message: fmt.Sprintf("%d %s SCTs, %d required", n, state, required)})
+		}
+	}
+	if i.CAAStatus() == CAAStatusUnauthorized {
+		findings = append(findings, Finding{Severity: FindingWarning, Subject: i.Subject.String(), Message: fmt.Sprintf("issuer %q not authorized by the CAA records of %s", caaIssuer(i), i.CAA.Domain)})
+	}
+	if !i.DANEMatched() {
+		findings = append(findings, Finding{Severity: FindingWarning, Subject: i.Subject.String(), Message: fmt.Sprintf("no TLSA record of %s matches the chain", i.DANE.Name)})
+	}
+	if i.HTTP != nil && i.HTTP.Error == nil {
+		findings = append(findings, evaluateHTTP(i)...)
+	}
+	return findings
+}
+
+// evaluateHTTP flags server errors, a missing HSTS header and redirects
+// leaving HTTPS
+func evaluateHTTP(i Response) []Finding {
+	var findings []Finding
+	add := func(severity, format string, args ...interface{}) {
+		findings = append(findings, Finding{Severity: severity, Subject: i.Endpoint(), Message: fmt.Sprintf(format, args...)})
+	}
+	if i.HTTP.Status >= 500 {
+		add(FindingCritical, "HTTP status %d", i.HTTP.Status)
+	}
+	if i.HTTP.HSTS == nil {
+		add(FindingWarning, "no Strict-Transport-Security header")
+	} else if i.HTTP.HSTS.MaxAge <= 0 {
+		add(FindingWarning, "Strict-Transport-Security max-age of 0")
+	}
+	if i.HTTP.Redirect() && strings.HasPrefix(strings.ToLower(i.HTTP.Location), "http://") {
+		add(FindingWarning, "redirect to plain HTTP %s", i.HTTP.Location)
+	}
+	return findings
+}
+
+// Evaluate checks every certificate of the presented chain, the leaf being
+// held to the server certificate requirements
+func (p Policy) Evaluate(chain []*x509.Certificate) []Finding {
+	var findings []Finding
+	for k, c := range chain {
+		info := NewCertInfo(c)
+		add := func(severity, format string, args ...interface{}) {
+			findings = append(findings, Finding{Severity: severity, Subject: info.Subject, Message: fmt.Sprintf(format, args...)})
+		}
+		selfSigned := c.CheckSignatureFrom(c) == nil
+
+		switch c.PublicKey.(type) {
+		case *rsa.PublicKey:
+			if info.KeySize < p.MinRSABits {
+				add(FindingCritical, "weak RSA key of %d bits, %d required", info.KeySize, p.MinRSABits)
+			}
+		case *ecdsa.PublicKey:
+			if info.KeySize < p.MinECDSABits {
+				add(FindingCritical, "weak ECDSA key on %s, %d bits required", info.Curve, p.MinECDSABits)
+			}
+		case *dsa.PublicKey:
+			add(FindingCritical, "DSA key")
+		}
+
+		// The signature of a trust anchor is never checked
+		if !selfSigned {
+			switch c.SignatureAlgorithm {
+			case x509.SHA1WithRSA, x509.ECDSAWithSHA1, x509.DSAWithSHA1:
+				add(FindingCritical, "SHA-1 signature (%s)", info.SignatureAlgorithm)
+			case x509.MD5WithRSA, x509.MD2WithRSA:
+				add(FindingCritical, "MD5/MD2 signature (%s)", info.SignatureAlgorithm)
+			}
+		}
+		if c.Version != 3 {
+			add(FindingWarning, "X.509 version %d certificate", c.Version)
+		}
+
+		if k == 0 {
+			p.evaluateLeaf(c, info, add)
+		} else if !c.BasicConstraintsValid || !c.IsCA {
+			add(FindingCritical, "issuing certificate is not a CA (basic constraints)")
+		}
+	}
+	return findings
+}
+
+func (p Policy) evaluateLeaf(c *x509.Certificate, info CertInfo, add func(string, string, ...interface{})) {
+	if info.Validity > p.MaxValidity {
+		add(FindingWarning, "validity of %d days, more than %d", int(info.Validity.Hours()/24), int(p.MaxValidity.Hours()/24))
+	}
+	if c.IsCA {
+		add(FindingWarning, "server certificate is a CA")
+	}
+	serverAuth := false
+	for _, u := range c.ExtKeyUsage {
+		if u == x509.ExtKeyUsageServerAuth || u == x509.ExtKeyUsageAny {
+			serverAuth = true
+		}
+	}
+	if !serverAuth {
+		add(FindingWarning, "missing serverAuth extended key usage")
+	}
+	if c.KeyUsage != 0 && c.KeyUsage&x509.KeyUsageDigitalSignature == 0 {
+		// RSA key exchange only needs keyEncipherment
+		if _, rsaKey := c.PublicKey.(*rsa.PublicKey); !rsaKey || c.KeyUsage&x509.KeyUsageKeyEncipherment == 0 {
+			add(FindingWarning, "key usage lacks digitalSignature")
+		}
+	}
+	if len(c.DNSNames) == 0 && len(c.IPAddresses) == 0 {
+		add(FindingWarning, "no subject alternative name")
+	}
+}
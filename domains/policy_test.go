@@ -0,0 +1,26 @@
+package domains
+
+import (
+	"crypto/x509"
+	"strings"
+	"testing"
+)
+
+func TestProbeFindings(t *testing.T) {
+	ca := newTestCA(t, "Test CA")
+	leaf := newTestCert(t, &x509.Certificate{
+		DNSNames: []string{"policy.example.test"},
+		KeyUsage: x509.KeyUsageDigitalSignature,
+	}, ca)
+	addr, errc := serveTLS(t, nil, leaf.tlsCertificate(ca))
+
+	resp := probe(t, "policy.example.test?connect="+addr, Options{TrustStore: testTrustStore(ca)})
+	<-errc
+	var found bool
+	for _, f := range resp.Findings {
+		found = found || strings.Contains(f.Message, "serverAuth")
+	}
+	if !found {
+		t.Errorf("got findings %v, want the missing serverAuth usage", resp.Findings)
+	}
+}
@@ -0,0 +1,167 @@
+package domains
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"golang.org/x/net/http/httpproxy"
+	"golang.org/x/net/proxy"
+)
+
+// ProxyDirect disables the proxy, including the one of the environment
+const ProxyDirect = "direct"
+
+// ParseProxy validates a proxy URL, http, https, socks5 or socks5h with
+// optional user:password credentials. A bare host:port is an HTTP proxy.
+func ParseProxy(raw string) (*url.URL, error) {
+	if !strings.Contains(raw, "://") {
+		raw = "http://" + raw
+	}
+	u, err := url.Parse(raw)
+	if err != nil {
+		return nil, fmt.Errorf("invalid proxy: %w", err)
+	}
+	switch u.Scheme {
+	case "http", "https", "socks5", "socks5h":
+	default:
+		return nil, fmt.Errorf("invalid proxy %s: unsupported scheme %s", u.Redacted(), u.Scheme)
+	}
+	if u.Host == "" {
+		return nil, fmt.Errorf("invalid proxy %s: missing host", u.Redacted())
+	}
+	return u, nil
+}
+
+// proxyFor returns the proxy addr is reached through, nil for a direct
+// connection. The configured proxy replaces HTTPS_PROXY, NO_PROXY applies
+// to both, and loopback addresses are never proxied.
+func (o Options) proxyFor(addr string) (*url.URL, error) {
+	if o.Proxy == ProxyDirect {
+		return nil, nil
+	}
+	cfg := httpproxy.FromEnvironment()
+	cfg.HTTPProxy = ""
+	if o.Proxy != "" {
+		cfg.HTTPSProxy = o.Proxy
+	}
+	if cfg.HTTPSProxy == "" {
+		return nil, nil
+	}
+	u, err := cfg.ProxyFunc()(&url.URL{Scheme: "https", Host: addr})
+	if err != nil || u == nil {
+		return u, err
+	}
+	return ParseProxy(u.String())
+}
+
+// dialSOCKS5 connects to addr through a SOCKS5 proxy, resolving its host
+// with the resolver of forward first unless the scheme is socks5h
+func dialSOCKS5(ctx context.Context, addr string, proxyURL *url.URL, forward *net.Dialer) (net.Conn, error) {
+	if proxyURL.Scheme == "socks5" {
+		host, port, err := net.SplitHostPort(addr)
+		if err != nil {
+			return nil, err
+		}
+		if net.ParseIP(host) == nil {
+			resolver := forward.Resolver
+			if resolver == nil {
+				resolver = net.DefaultResolver
+			}
+			ips, err := resolver.LookupIPAddr(ctx, host)
+			if err != nil {
+				return nil, err
+			}
+			if len(ips) == 0 {
+				return nil, &net.DNSError{Err: "no address", Name: host, IsNotFound: true}
+			}
+			addr = net.JoinHostPort(ips[0].IP.String(), port)
+		}
+	}
+	var auth *proxy.Auth
+	if proxyURL.User != nil {
+		password, _ := proxyURL.User.Password()
+		auth = &proxy.Auth{User: proxyURL.User.Username(), Password: password}
+	}
+	dialer, err := proxy.SOCKS5("tcp", proxyURL.Host, auth, forward)
+	if err != nil {
+		return nil, err
+	}
+	return dialer.(proxy.ContextDialer).DialContext(ctx, "tcp", addr)
+}
+
+// dialConnect opens a tunnel to addr with an HTTP CONNECT request, over TLS
+// for https proxies
+func dialConnect(ctx context.Context, addr string, proxyURL *url.URL, forward *net.Dialer, deadline time.Time) (net.Conn, error) {
+	host := proxyURL.Host
+	if proxyURL.Port() == "" {
+		port := "80"
+		if proxyURL.Scheme == "https" {
+			port = "443"
+		}
+		host = net.JoinHostPort(proxyURL.Hostname(), port)
+	}
+	conn, err := forward.DialContext(ctx, "tcp", host)
+	if err != nil {
+		return nil, err
+	}
+	conn.SetDeadline(deadline)
+	if proxyURL.Scheme == "https" {
+		tlsConn := tls.Client(conn, &tls.Config{ServerName: proxyURL.Hostname()})
+		if err := tlsConn.HandshakeContext(ctx); err != nil {
+			conn.Close()
+			return nil, err
+		}
+		conn = tlsConn
+	}
+
+	req := &http.Request{
+		Method: http.MethodConnect,
+		URL:    &url.URL{Opaque: addr},
+		Host:   addr,
+		Header: http.Header{},
+	}
+	if proxyURL.User != nil {
+		password, _ := proxyURL.User.Password()
+		credentials := base64.StdEncoding.EncodeToString([]byte(proxyURL.User.Username() + ":" + password))
+		req.Header.Set("Proxy-Authorization", "Basic "+credentials)
+	}
+	if err := req.Write(conn); err != nil {
+		conn.Close()
+		return nil, err
+	}
+	reader := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(reader, req)
+	if err != nil {
+		conn.Close()
+		return nil, err
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		conn.Close()
+		return nil, fmt.Errorf("CONNECT %s refused: %s", addr, resp.Status)
+	}
+	// The server speaks first in STARTTLS protocols, keep what was read ahead
+	if reader.Buffered() > 0 {
+		return &bufferedConn{Conn: conn, reader: reader}, nil
+	}
+	return conn, nil
+}
+
+// bufferedConn reads what was buffered while parsing the CONNECT answer
+// before reading from the connection
+type bufferedConn struct {
+	net.Conn
+	reader *bufio.Reader
+}
+
+func (c *bufferedConn) Read(b []byte) (int, error) {
+	return c.reader.Read(b)
+}
@@ -0,0 +1,312 @@
+package domains
+
+import (
+	"bufio"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"sync"
+	"testing"
+
+	"golang.org/x/net/dns/dnsmessage"
+)
+
+// proxyRecorder records the addresses a test proxy was asked for
+type proxyRecorder struct {
+	URL string
+
+	mu    sync.Mutex
+	hosts []string
+}
+
+func (p *proxyRecorder) record(host string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.hosts = append(p.hosts, host)
+}
+
+// tunnels returns the hosts asked for so far
+func (p *proxyRecorder) tunnels() []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]string(nil), p.hosts...)
+}
+
+// serveProxy accepts the connections of a test proxy on a new listener
+func serveProxy(t *testing.T, serve func(net.Conn)) string {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				serve(conn)
+			}()
+		}
+	}()
+	return l.Addr().String()
+}
+
+// pipe copies between the client of a proxy and backend until both are done
+func pipe(client net.Conn, r io.Reader, backend net.Conn) {
+	go func() {
+		io.Copy(backend, r)
+		backend.(*net.TCPConn).CloseWrite()
+	}()
+	io.Copy(client, backend)
+}
+
+// connectProxy is an HTTP CONNECT proxy tunneling every request to backend,
+// whatever the host asked for, and recording those hosts
+type connectProxy struct {
+	proxyRecorder
+}
+
+// newConnectProxy starts a CONNECT proxy, requiring user:password
+// credentials when set
+func newConnectProxy(t *testing.T, backend, credentials string) *connectProxy {
+	t.Helper()
+	p := &connectProxy{}
+	addr := serveProxy(t, func(conn net.Conn) {
+		r := bufio.NewReader(conn)
+		req, err := http.ReadRequest(r)
+		if err != nil || req.Method != http.MethodConnect {
+			return
+		}
+		if credentials != "" && req.Header.Get("Proxy-Authorization") != "Basic "+base64.StdEncoding.EncodeToString([]byte(credentials)) {
+			io.WriteString(conn, "HTTP/1.1 407 Proxy Authentication Required\r\n\r\n")
+			return
+		}
+		p.record(req.Host)
+		upstream, err := net.Dial("tcp", backend)
+		if err != nil {
+			io.WriteString(conn, "HTTP/1.1 502 Bad Gateway\r\n\r\n")
+			return
+		}
+		defer upstream.Close()
+		io.WriteString(conn, "HTTP/1.1 200 Connection established\r\n\r\n")
+		pipe(conn, r, upstream)
+	})
+	p.URL = "http://" + addr
+	if credentials != "" {
+		p.URL = "http://" + credentials + "@" + addr
+	}
+	return p
+}
+
+// newSOCKS5Proxy starts a SOCKS5 proxy (RFC 1928) tunneling every request
+// to backend, requiring user:password credentials (RFC 1929) when set
+func newSOCKS5Proxy(t *testing.T, backend, credentials string) *proxyRecorder {
+	t.Helper()
+	p := &proxyRecorder{}
+	addr := serveProxy(t, func(conn net.Conn) {
+		r := bufio.NewReader(conn)
+		if err := socks5Handshake(conn, r, credentials); err != nil {
+			return
+		}
+		// VER CMD RSV ATYP
+		head := make([]byte, 4)
+		if _, err := io.ReadFull(r, head); err != nil || head[1] != 1 {
+			return
+		}
+		var host string
+		switch head[3] {
+		case 1, 4:
+			ip := make([]byte, 4)
+			if head[3] == 4 {
+				ip = make([]byte, 16)
+			}
+			if _, err := io.ReadFull(r, ip); err != nil {
+				return
+			}
+			host = net.IP(ip).String()
+		case 3:
+			n, err := r.ReadByte()
+			if err != nil {
+				return
+			}
+			name := make([]byte, n)
+			if _, err := io.ReadFull(r, name); err != nil {
+				return
+			}
+			host = string(name)
+		default:
+			return
+		}
+		port := make([]byte, 2)
+		if _, err := io.ReadFull(r, port); err != nil {
+			return
+		}
+		p.record(net.JoinHostPort(host, strconv.Itoa(int(binary.BigEndian.Uint16(port)))))
+		upstream, err := net.Dial("tcp", backend)
+		if err != nil {
+			conn.Write([]byte{5, 5, 0, 1, 0, 0, 0, 0, 0, 0})
+			return
+		}
+		defer upstream.Close()
+		conn.Write([]byte{5, 0, 0, 1, 127, 0, 0, 1, 0, 0})
+		pipe(conn, r, upstream)
+	})
+	p.URL = "socks5://" + addr
+	if credentials != "" {
+		p.URL = "socks5://" + credentials + "@" + addr
+	}
+	return p
+}
+
+// socks5Handshake negotiates the authentication method of a SOCKS5 client
+func socks5Handshake(conn net.Conn, r *bufio.Reader, credentials string) error {
+	head := make([]byte, 2)
+	if _, err := io.ReadFull(r, head); err != nil {
+		return err
+	}
+	methods := make([]byte, head[1])
+	if _, err := io.ReadFull(r, methods); err != nil {
+		return err
+	}
+	if credentials == "" {
+		_, err := conn.Write([]byte{5, 0})
+		return err
+	}
+	conn.Write([]byte{5, 2})
+	// VER ULEN UNAME PLEN PASSWD
+	field := func() (string, error) {
+		n, err := r.ReadByte()
+		if err != nil {
+			return "", err
+		}
+		b := make([]byte, n)
+		_, err = io.ReadFull(r, b)
+		return string(b), err
+	}
+	if _, err := r.ReadByte(); err != nil {
+		return err
+	}
+	user, err := field()
+	if err != nil {
+		return err
+	}
+	password, err := field()
+	if err != nil {
+		return err
+	}
+	if user+":"+password != credentials {
+		conn.Write([]byte{1, 1})
+		return errors.New("bad credentials")
+	}
+	_, err = conn.Write([]byte{1, 0})
+	return err
+}
+
+// proxyTarget serves a certificate for name and returns the target of a
+// probe of it and the TLS listener address
+func proxyTarget(t *testing.T, name string) (target, backend string, ca *testCert) {
+	t.Helper()
+	ca = newTestCA(t, "Test CA")
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	serveTLSLoop(t, l, newTestLeaf(t, ca, name).tlsCertificate(ca))
+	_, port, _ := net.SplitHostPort(l.Addr().String())
+	return name + ":" + port, l.Addr().String(), ca
+}
+
+func TestProxyConnectCredentials(t *testing.T) {
+	target, backend, ca := proxyTarget(t, "www.example.test")
+	proxy := newConnectProxy(t, backend, "checker:s3cret")
+
+	resp := probe(t, target, Options{Proxy: proxy.URL, TrustStore: testTrustStore(ca)})
+	if resp.Error != nil || !resp.Verified {
+		t.Fatalf("probe failed: %v", resp.Error)
+	}
+	if got := proxy.tunnels(); len(got) != 1 || got[0] != target {
+		t.Errorf("got tunnels %v, want %s", got, target)
+	}
+	u, _ := url.Parse(proxy.URL)
+	if want := "http://checker:xxxxx@" + u.Host; resp.Proxy != want {
+		t.Errorf("got proxy %q, want the redacted %q", resp.Proxy, want)
+	}
+
+	u.User = url.UserPassword("checker", "wrong")
+	resp = probe(t, target, Options{Proxy: u.String(), TrustStore: testTrustStore(ca)})
+	if resp.ErrorCode != ErrCodeProxy {
+		t.Errorf("got error code %q (%v) with bad credentials, want %q", resp.ErrorCode, resp.Error, ErrCodeProxy)
+	}
+}
+
+func TestProxySOCKS5(t *testing.T) {
+	target, backend, ca := proxyTarget(t, "www.example.test")
+	_, port, _ := net.SplitHostPort(target)
+	resolver := newDNSStub(t, dnsZone{
+		"www.example.test.": {dnsmessage.TypeA: {&dnsmessage.AResource{A: [4]byte{192, 0, 2, 10}}}},
+	})
+
+	tests := []struct {
+		scheme      string
+		credentials string
+		// want is the address the proxy is asked for
+		want string
+	}{
+		{"socks5", "", "192.0.2.10:" + port},
+		{"socks5", "checker:s3cret", "192.0.2.10:" + port},
+		{"socks5h", "", target},
+		{"socks5h", "checker:s3cret", target},
+	}
+	for _, tt := range tests {
+		t.Run(tt.scheme+" "+tt.credentials, func(t *testing.T) {
+			proxy := newSOCKS5Proxy(t, backend, tt.credentials)
+			u, _ := url.Parse(proxy.URL)
+			u.Scheme = tt.scheme
+
+			resp := probe(t, target, Options{Proxy: u.String(), Resolver: resolver, TrustStore: testTrustStore(ca)})
+			if resp.Error != nil || !resp.Verified {
+				t.Fatalf("probe failed: %v", resp.Error)
+			}
+			if got := proxy.tunnels(); len(got) != 1 || got[0] != tt.want {
+				t.Errorf("got tunnels %v, want %s", got, tt.want)
+			}
+			if want := u.Redacted(); resp.Proxy != want || (tt.credentials != "" && resp.Proxy == u.String()) {
+				t.Errorf("got proxy %q, want the redacted %q", resp.Proxy, want)
+			}
+
+			if tt.credentials != "" {
+				u.User = url.UserPassword("checker", "wrong")
+				resp := probe(t, target, Options{Proxy: u.String(), Resolver: resolver, TrustStore: testTrustStore(ca)})
+				if resp.ErrorCode != ErrCodeProxy {
+					t.Errorf("got error code %q (%v) with bad credentials, want %q", resp.ErrorCode, resp.Error, ErrCodeProxy)
+				}
+			}
+		})
+	}
+}
+
+func TestParseProxy(t *testing.T) {
+	for raw, want := range map[string]string{
+		"proxy.example.test:3128":           "http://proxy.example.test:3128",
+		"https://u:p@proxy.example.test":    "https://u:p@proxy.example.test",
+		"socks5h://proxy.example.test:1080": "socks5h://proxy.example.test:1080",
+	} {
+		if u, err := ParseProxy(raw); err != nil || u.String() != want {
+			t.Errorf("ParseProxy(%q) = %v, %v; want %s", raw, u, err, want)
+		}
+	}
+	for _, raw := range []string{"ftp://proxy.example.test", "http://"} {
+		if _, err := ParseProxy(raw); err == nil {
+			t.Errorf("ParseProxy(%q) accepted", raw)
+		}
+	}
+}
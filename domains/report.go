@@ -0,0 +1,696 @@
+package domains
+
+import (
+	"crypto/x509"
+	"encoding/csv"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	DefaultReportFile   = "./report.md"
+	DefaultReportFormat = "markdown"
+)
+
+// ReportWriter renders a set of responses grouped by environment
+type ReportWriter interface {
+	Write(w io.Writer, domains []Response, queries []string) error
+}
+
+var reportWriters = map[string]ReportWriter{
+	"markdown": markdownReport{},
+	"json":     jsonReport{},
+	"yaml":     yamlReport{},
+	"csv":      csvReport{},
+}
+
+// ReportFormats lists the supported report formats
+func ReportFormats() []string {
+	formats := make([]string, 0, len(reportWriters))
+	for f := range reportWriters {
+		formats = append(formats, f)
+	}
+	sort.Strings(formats)
+	return formats
+}
+
+// NewReportWriter returns the writer for a report format
+func NewReportWriter(format string) (ReportWriter, error) {
+	w, ok := reportWriters[strings.ToLower(format)]
+	if !ok {
+		return nil, fmt.Errorf("unsupported report format %q, expected one of %s", format, strings.Join(ReportFormats(), ", "))
+	}
+	return w, nil
+}
+
+// ReportFormatFromFile guesses the report format from a file extension,
+// defaulting to markdown
+func ReportFormatFromFile(fileName string) string {
+	switch strings.ToLower(filepath.Ext(fileName)) {
+	case ".json":
+		return "json"
+	case ".yaml", ".yml":
+		return "yaml"
+	case ".csv":
+		return "csv"
+	default:
+		return DefaultReportFormat
+	}
+}
+
+func CreateReport(domains []Response, queries []string, fileName, format string, stdOut bool) error {
+	writer, err := NewReportWriter(format)
+	if err != nil {
+		return err
+	}
+
+	// We don't save the report on disk if stdOut is requested
+	if stdOut {
+		return writer.Write(os.Stdout, domains, queries)
+	}
+
+	file, err := os.OpenFile(fileName, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	if err := writer.Write(file, domains, queries); err != nil {
+		file.Close()
+		return err
+	}
+	return file.Close()
+}
+
+// groupByEnv returns the responses of each environment, in queries order,
+// sorted by expiry date
+func groupByEnv(domains []Response, queries []string) [][]Response {
+	groups := [][]Response{}
+	for _, env := range queries {
+		group := []Response{}
+		for _, d := range domains {
+			if d.Environment == env {
+				group = append(group, d)
+			}
+		}
+		if len(group) == 0 {
+			continue
+		}
+		sortByExpiry(group)
+		groups = append(groups, group)
+	}
+	return groups
+}
+
+func sortByExpiry(domains []Response) {
+	now := time.Now()
+	sort.Slice(domains, func(i, j int) bool {
+		// We want to move errors (null date value) to the end of list
+		// adding 99 years there - XXX find a more elegant way
+		iDate := domains[i].Expiry()
+		jDate := domains[j].Expiry()
+		if iDate.IsZero() {
+			iDate = now.AddDate(99, 0, 0)
+		}
+		if jDate.IsZero() {
+			jDate = now.AddDate(99, 0, 0)
+		}
+		return iDate.Before(jDate)
+	})
+}
+
+type markdownReport struct{}
+
+// markdownIssuer returns the issuer column of a response, followed by the
+// findings on its certificate, or the error when no certificate was captured
+func markdownIssuer(d Response) string {
+	if !d.HasCert() {
+		if d.Error != nil {
+			return d.Error.Error()
+		}
+		return ""
+	}
+	issuer := d.Issuer.String()
+	if d.SerialMismatch() {
+		issuer += " (backends serials differ)"
+	}
+	if deprecated := d.Deprecated(); len(deprecated) > 0 {
+		issuer += " (deprecated: " + strings.Join(deprecated, ", ") + ")"
+	}
+	if findings := d.Findings; len(findings) > 0 {
+		issuer += fmt.Sprintf(" (%d findings)", len(findings))
+	}
+	if d.Error != nil {
+		issuer += " (" + d.KnownError() + ")"
+	}
+	return issuer
+}
+
+// markdownColumn is an optional column of the markdown report, only shown
+// when a response has a value for it
+type markdownColumn struct {
+	header string
+	value  func(Response) string
+	width  int
+}
+
+var markdownColumns = []markdownColumn{
+	{header: "Revocation", value: Response.Revocation},
+	{header: "TLSA", value: func(d Response) string { return tlsaColumn(newReportTLSA(d.DANE)) }},
+}
+
+func (markdownReport) Write(w io.Writer, domains []Response, queries []string) error {
+	var file strings.Builder
+
+	headers := []string{"Endpoint", "Expiration", "Issuer"}
+
+	file.WriteString("# TLS check Domain report\n")
+	file.WriteString("\n")
+
+	var domainWidth int
+	var issuerWidth int
+	var columns []markdownColumn
+	for _, c := range markdownColumns {
+		for _, i := range domains {
+			if size := utf8.RuneCountInString(c.value(i)); size > c.width {
+				c.width = size
+			}
+		}
+		if c.width > 0 {
+			c.width = max(c.width, len(c.header))
+			columns = append(columns, c)
+		}
+	}
+	for _, i := range domains {
+		dSize := utf8.RuneCountInString(i.Endpoint())
+		iSize := utf8.RuneCountInString(markdownIssuer(i))
+		if dSize > domainWidth {
+			domainWidth = dSize
+		}
+		if iSize > issuerWidth {
+			issuerWidth = iSize
+		}
+	}
+	domainWidth = -1 * domainWidth
+	issuerWidth = -1 * issuerWidth
+
+	for _, domains := range groupByEnv(domains, queries) {
+		file.WriteString(fmt.Sprintf("## Domains for %v\n", domains[0].Environment))
+		file.WriteString("\n")
+		file.WriteString(fmt.Sprintf("| %*s | %-10s | %-*s |", domainWidth, headers[0], headers[1], issuerWidth, headers[2]))
+		for _, c := range columns {
+			file.WriteString(fmt.Sprintf(" %-*s |", c.width, c.header))
+		}
+		file.WriteString("\n")
+		file.WriteString(fmt.Sprintf("|-%s-|-%-10s-|-%-*s-|", strings.Repeat("-", -1*domainWidth), strings.Repeat("-", 10), issuerWidth, strings.Repeat("-", -1*issuerWidth)))
+		for _, c := range columns {
+			file.WriteString(strings.Repeat("-", c.width+2) + "|")
+		}
+		file.WriteString("\n")
+
+		for _, d := range domains {
+			expiry := "NA"
+			if d.HasCert() {
+				expiry = d.Expiry().Format("2006-01-02")
+			}
+			file.WriteString(fmt.Sprintf("| %*s | %-10s | %-*s |", domainWidth, d.Endpoint(), expiry, issuerWidth, markdownIssuer(d)))
+			for _, c := range columns {
+				file.WriteString(fmt.Sprintf(" %-*s |", c.width, c.value(d)))
+			}
+			file.WriteString("\n")
+		}
+		file.WriteString("\n")
+	}
+
+	_, err := io.WriteString(w, file.String())
+	return err
+}
+
+// reportCert is the machine readable form of a chain member
+type reportCert struct {
+	Subject   string `json:"subject" yaml:"subject"`
+	Issuer    string `json:"issuer" yaml:"issuer"`
+	Serial    string `json:"serial" yaml:"serial"`
+	NotBefore string `json:"not_before" yaml:"not_before"`
+	NotAfter  string `json:"not_after" yaml:"not_after"`
+	// Key and signature details, see CertInfo
+	KeyAlgorithm       string   `json:"key_algorithm" yaml:"key_algorithm"`
+	KeySize            int      `json:"key_size" yaml:"key_size"`
+	Curve              string   `json:"curve,omitempty" yaml:"curve,omitempty"`
+	SignatureAlgorithm string   `json:"signature_algorithm" yaml:"signature_algorithm"`
+	Version            int      `json:"version" yaml:"version"`
+	IsCA               bool     `json:"is_ca" yaml:"is_ca"`
+	KeyUsage           []string `json:"key_usage,omitempty" yaml:"key_usage,omitempty"`
+	ExtKeyUsage        []string `json:"ext_key_usage,omitempty" yaml:"ext_key_usage,omitempty"`
+}
+
+// reportFinding is the machine readable form of a Finding
+type reportFinding struct {
+	Severity string `json:"severity" yaml:"severity"`
+	Subject  string `json:"subject" yaml:"subject"`
+	Message  string `json:"message" yaml:"message"`
+}
+
+// reportEntry is the machine readable form of a Response
+type reportEntry struct {
+	Environment string `json:"environment" yaml:"environment"`
+	Endpoint    string `json:"endpoint" yaml:"endpoint"`
+	Domain      string `json:"domain" yaml:"domain"`
+	Port        string `json:"port" yaml:"port"`
+	Protocol    string `json:"protocol" yaml:"protocol"`
+	Address     string `json:"address,omitempty" yaml:"address,omitempty"`
+	Connect     string `json:"connect,omitempty" yaml:"connect,omitempty"`
+	Proxy       string `json:"proxy,omitempty" yaml:"proxy,omitempty"`
+	ServerName  string `json:"server_name" yaml:"server_name"`
+	VerifyName  string `json:"verify_name,omitempty" yaml:"verify_name,omitempty"`
+	TrustStore  string `json:"trust_store,omitempty" yaml:"trust_store,omitempty"`
+	// ClientCertRequested is set when the server asked for mutual TLS
+	ClientCertRequested bool            `json:"client_cert_requested" yaml:"client_cert_requested"`
+	AcceptableCAs       []string        `json:"acceptable_cas,omitempty" yaml:"acceptable_cas,omitempty"`
+	Subject             string          `json:"subject,omitempty" yaml:"subject,omitempty"`
+	Issuer              string          `json:"issuer,omitempty" yaml:"issuer,omitempty"`
+	Serial              string          `json:"serial,omitempty" yaml:"serial,omitempty"`
+	NotBefore           string          `json:"not_before,omitempty" yaml:"not_before,omitempty"`
+	NotAfter            string          `json:"not_after,omitempty" yaml:"not_after,omitempty"`
+	Expiry              string          `json:"expiry,omitempty" yaml:"expiry,omitempty"`
+	SAN                 []string        `json:"san,omitempty" yaml:"san,omitempty"`
+	Chain               []reportCert    `json:"chain,omitempty" yaml:"chain,omitempty"`
+	VerifiedChain       []reportCert    `json:"verified_chain,omitempty" yaml:"verified_chain,omitempty"`
+	Verified            bool            `json:"verified" yaml:"verified"`
+	VerifyError         string          `json:"verify_error,omitempty" yaml:"verify_error,omitempty"`
+	Revocation          string          `json:"revocation,omitempty" yaml:"revocation,omitempty"`
+	OCSPStapled         *reportOCSP     `json:"ocsp_stapled,omitempty" yaml:"ocsp_stapled,omitempty"`
+	OCSPResponder       *reportOCSP     `json:"ocsp_responder,omitempty" yaml:"ocsp_responder,omitempty"`
+	TLSVersion          string          `json:"tls_version,omitempty" yaml:"tls_version,omitempty"`
+	CipherSuite         string          `json:"cipher_suite,omitempty" yaml:"cipher_suite,omitempty"`
+	ALPN                string          `json:"alpn,omitempty" yaml:"alpn,omitempty"`
+	ScanVersions        []string        `json:"scan_versions,omitempty" yaml:"scan_versions,omitempty"`
+	ScanSuites          []string        `json:"scan_suites,omitempty" yaml:"scan_suites,omitempty"`
+	Deprecated          []string        `json:"deprecated,omitempty" yaml:"deprecated,omitempty"`
+	Findings            []reportFinding `json:"findings,omitempty" yaml:"findings,omitempty"`
+	CRL                 []reportCRL     `json:"crl,omitempty" yaml:"crl,omitempty"`
+	StaleCRL            bool            `json:"stale_crl,omitempty" yaml:"stale_crl,omitempty"`
+	SCTs                []reportSCT     `json:"scts,omitempty" yaml:"scts,omitempty"`
+	ValidSCTs           int             `json:"valid_scts" yaml:"valid_scts"`
+	UnverifiedSCTs      int             `json:"unverified_scts,omitempty" yaml:"unverified_scts,omitempty"`
+	CAA                 *reportCAA      `json:"caa,omitempty" yaml:"caa,omitempty"`
+	TLSA                []reportTLSA    `json:"tlsa,omitempty" yaml:"tlsa,omitempty"`
+	HTTP                *reportHTTP     `json:"http,omitempty" yaml:"http,omitempty"`
+	Attempts            int             `json:"attempts" yaml:"attempts"`
+	AttemptErrors       []string        `json:"attempt_errors,omitempty" yaml:"attempt_errors,omitempty"`
+	Error               string          `json:"error,omitempty" yaml:"error,omitempty"`
+	ErrorClass          string          `json:"error_class,omitempty" yaml:"error_class,omitempty"`
+	// Backends are set when every resolved address was probed
+	SerialMismatch bool          `json:"serial_mismatch,omitempty" yaml:"serial_mismatch,omitempty"`
+	Backends       []reportEntry `json:"backends,omitempty" yaml:"backends,omitempty"`
+}
+
+// reportOCSP is the machine readable form of an OCSPResult
+type reportOCSP struct {
+	Status     string `json:"status,omitempty" yaml:"status,omitempty"`
+	ThisUpdate string `json:"this_update,omitempty" yaml:"this_update,omitempty"`
+	NextUpdate string `json:"next_update,omitempty" yaml:"next_update,omitempty"`
+	RevokedAt  string `json:"revoked_at,omitempty" yaml:"revoked_at,omitempty"`
+	Responder  string `json:"responder,omitempty" yaml:"responder,omitempty"`
+	Error      string `json:"error,omitempty" yaml:"error,omitempty"`
+}
+
+// reportCAA is the machine readable form of a CAAResult
+type reportCAA struct {
+	Domain     string   `json:"domain,omitempty" yaml:"domain,omitempty"`
+	Issue      []string `json:"issue,omitempty" yaml:"issue,omitempty"`
+	IssueWild  []string `json:"issuewild,omitempty" yaml:"issuewild,omitempty"`
+	Iodef      []string `json:"iodef,omitempty" yaml:"iodef,omitempty"`
+	Authorized bool     `json:"authorized" yaml:"authorized"`
+	Status     string   `json:"status" yaml:"status"`
+	Error      string   `json:"error,omitempty" yaml:"error,omitempty"`
+}
+
+func newReportCAA(d Response) *reportCAA {
+	if d.CAA == nil {
+		return nil
+	}
+	c := &reportCAA{
+		Domain:     d.CAA.Domain,
+		Issue:      d.CAA.Issue,
+		IssueWild:  d.CAA.IssueWild,
+		Iodef:      d.CAA.Iodef,
+		Authorized: d.CAAStatus() == CAAStatusAuthorized,
+		Status:     d.CAAStatus(),
+	}
+	if d.CAA.Error != nil {
+		c.Error = d.CAA.Error.Error()
+	}
+	return c
+}
+
+// reportHTTP is the machine readable form of an HTTPResult
+type reportHTTP struct {
+	Method   string `json:"method,omitempty" yaml:"method,omitempty"`
+	Proto    string `json:"proto,omitempty" yaml:"proto,omitempty"`
+	Status   int    `json:"status,omitempty" yaml:"status,omitempty"`
+	Location string `json:"location,omitempty" yaml:"location,omitempty"`
+	Server   string `json:"server,omitempty" yaml:"server,omitempty"`
+	// HSTS is the raw header, its directives follow
+	HSTS              string `json:"hsts,omitempty" yaml:"hsts,omitempty"`
+	HSTSMaxAge        int64  `json:"hsts_max_age,omitempty" yaml:"hsts_max_age,omitempty"`
+	IncludeSubDomains bool   `json:"hsts_include_subdomains,omitempty" yaml:"hsts_include_subdomains,omitempty"`
+	Preload           bool   `json:"hsts_preload,omitempty" yaml:"hsts_preload,omitempty"`
+	Error             string `json:"error,omitempty" yaml:"error,omitempty"`
+}
+
+func newReportHTTP(h *HTTPResult) *reportHTTP {
+	if h == nil {
+		return nil
+	}
+	r := &reportHTTP{
+		Method:   h.Method,
+		Proto:    h.Proto,
+		Status:   h.Status,
+		Location: h.Location,
+		Server:   h.Server,
+	}
+	if h.HSTS != nil {
+		r.HSTS = h.HSTS.Raw
+		r.HSTSMaxAge = int64(h.HSTS.MaxAge.Seconds())
+		r.IncludeSubDomains = h.HSTS.IncludeSubDomains
+		r.Preload = h.HSTS.Preload
+	}
+	if h.Error != nil {
+		r.Error = h.Error.Error()
+	}
+	return r
+}
+
+// reportTLSA is the machine readable form of a TLSARecord
+type reportTLSA struct {
+	Name         string `json:"name" yaml:"name"`
+	Usage        uint8  `json:"usage" yaml:"usage"`
+	Selector     uint8  `json:"selector" yaml:"selector"`
+	MatchingType uint8  `json:"matching_type" yaml:"matching_type"`
+	Data         string `json:"data" yaml:"data"`
+	Matched      bool   `json:"matched" yaml:"matched"`
+	Error        string `json:"error,omitempty" yaml:"error,omitempty"`
+}
+
+func newReportTLSA(d *DANEResult) []reportTLSA {
+	if d == nil {
+		return nil
+	}
+	var records []reportTLSA
+	for _, r := range d.Records {
+		t := reportTLSA{Name: d.Name, Usage: r.Usage, Selector: r.Selector, MatchingType: r.MatchingType, Data: r.Data, Matched: r.Matched}
+		if r.Error != nil {
+			t.Error = r.Error.Error()
+		}
+		records = append(records, t)
+	}
+	if d.Error != nil {
+		records = append(records, reportTLSA{Name: d.Name, Error: d.Error.Error()})
+	}
+	return records
+}
+
+// tlsaColumn flattens TLSA records for single column outputs
+func tlsaColumn(records []reportTLSA) string {
+	values := make([]string, 0, len(records))
+	for _, r := range records {
+		switch {
+		case r.Error != "" && r.Data == "":
+			values = append(values, "error: "+r.Error)
+		case r.Matched:
+			values = append(values, fmt.Sprintf("%d %d %d matched", r.Usage, r.Selector, r.MatchingType))
+		default:
+			values = append(values, fmt.Sprintf("%d %d %d unmatched", r.Usage, r.Selector, r.MatchingType))
+		}
+	}
+	return strings.Join(values, ";")
+}
+
+// caaColumn returns the CAA status for single column outputs, empty when
+// the records were not looked up
+func caaColumn(c *reportCAA) string {
+	if c == nil {
+		return ""
+	}
+	if c.Error != "" {
+		return "error: " + c.Error
+	}
+	return c.Status
+}
+
+// reportSCT is the machine readable form of an SCT
+type reportSCT struct {
+	Source    string `json:"source" yaml:"source"`
+	LogID     string `json:"log_id,omitempty" yaml:"log_id,omitempty"`
+	Log       string `json:"log,omitempty" yaml:"log,omitempty"`
+	Timestamp string `json:"timestamp,omitempty" yaml:"timestamp,omitempty"`
+	Verified  bool   `json:"verified" yaml:"verified"`
+	Error     string `json:"error,omitempty" yaml:"error,omitempty"`
+}
+
+func newReportSCT(s SCT) reportSCT {
+	r := reportSCT{
+		Source:    s.Source,
+		LogID:     s.LogID,
+		Log:       s.Log,
+		Timestamp: formatTime(s.Timestamp),
+		Verified:  s.Verified,
+	}
+	if s.Error != nil {
+		r.Error = s.Error.Error()
+	}
+	return r
+}
+
+// reportCRL is the machine readable form of a CRLResult
+type reportCRL struct {
+	Subject    string `json:"subject" yaml:"subject"`
+	URL        string `json:"url" yaml:"url"`
+	Status     string `json:"status,omitempty" yaml:"status,omitempty"`
+	ThisUpdate string `json:"this_update,omitempty" yaml:"this_update,omitempty"`
+	NextUpdate string `json:"next_update,omitempty" yaml:"next_update,omitempty"`
+	RevokedAt  string `json:"revoked_at,omitempty" yaml:"revoked_at,omitempty"`
+	Stale      bool   `json:"stale,omitempty" yaml:"stale,omitempty"`
+	Error      string `json:"error,omitempty" yaml:"error,omitempty"`
+}
+
+func newReportCRL(r CRLResult) reportCRL {
+	c := reportCRL{
+		Subject:    r.Subject,
+		URL:        r.URL,
+		Status:     r.Status,
+		ThisUpdate: formatTime(r.ThisUpdate),
+		NextUpdate: formatTime(r.NextUpdate),
+		RevokedAt:  formatTime(r.RevokedAt),
+		Stale:      r.Stale,
+	}
+	if r.Error != nil {
+		c.Error = r.Error.Error()
+	}
+	return c
+}
+
+func newReportOCSP(r *OCSPResult) *reportOCSP {
+	if r == nil {
+		return nil
+	}
+	o := &reportOCSP{
+		Status:     r.Status,
+		ThisUpdate: formatTime(r.ThisUpdate),
+		NextUpdate: formatTime(r.NextUpdate),
+		RevokedAt:  formatTime(r.RevokedAt),
+		Responder:  r.Responder,
+	}
+	if r.Error != nil {
+		o.Error = r.Error.Error()
+	}
+	return o
+}
+
+func formatTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+	return t.UTC().Format(time.RFC3339)
+}
+
+func newReportEntry(d Response) reportEntry {
+	e := reportEntry{
+		Environment: d.Environment,
+		Endpoint:    d.Endpoint(),
+		Domain:      d.Domain,
+		Port:        d.Port,
+		Protocol:    d.Protocol,
+		Address:     d.Address,
+		Connect:     d.Connect,
+		Proxy:       d.Proxy,
+		ServerName:  d.ServerName,
+		VerifyName:  d.VerifyName,
+		TrustStore:  d.TrustStore,
+
+		ClientCertRequested: d.ClientCertRequested,
+		AcceptableCAs:       d.AcceptableCAs,
+		SAN:                 d.SAN,
+		Verified:            d.Verified,
+		Attempts:            d.Attempts,
+	}
+	for _, b := range d.Backends {
+		e.Backends = append(e.Backends, newReportEntry(b))
+	}
+	e.SerialMismatch = d.SerialMismatch()
+	for _, err := range d.AttemptErrors {
+		e.AttemptErrors = append(e.AttemptErrors, err.Error())
+	}
+	if d.Error != nil {
+		e.Error = d.Error.Error()
+		e.ErrorClass = string(d.ErrorCode)
+	}
+	if d.VerifyError != nil {
+		e.VerifyError = d.VerifyError.Error()
+	}
+	if !d.HasCert() {
+		return e
+	}
+	e.Subject = d.Subject.String()
+	e.Issuer = d.Issuer.String()
+	if d.SerialNumber != nil {
+		e.Serial = d.SerialNumber.Text(16)
+	}
+	e.TLSVersion = VersionName(d.TLSVersion)
+	e.CipherSuite = SuiteName(d.CipherSuite)
+	e.ALPN = d.ALPN
+	if d.Scan != nil {
+		for _, v := range d.Scan.Versions {
+			e.ScanVersions = append(e.ScanVersions, VersionName(v))
+		}
+		for _, s := range d.Scan.Suites {
+			e.ScanSuites = append(e.ScanSuites, s.String())
+		}
+	}
+	e.Deprecated = d.Deprecated()
+	for _, f := range d.Findings {
+		e.Findings = append(e.Findings, reportFinding{Severity: f.Severity, Subject: f.Subject, Message: f.Message})
+	}
+	e.Revocation = d.Revocation()
+	e.OCSPStapled = newReportOCSP(d.OCSPStapled)
+	e.OCSPResponder = newReportOCSP(d.OCSPResponder)
+	for _, r := range d.CRL {
+		e.CRL = append(e.CRL, newReportCRL(r))
+	}
+	e.StaleCRL = d.StaleCRL()
+	for _, s := range d.SCTs {
+		e.SCTs = append(e.SCTs, newReportSCT(s))
+	}
+	e.ValidSCTs = d.ValidSCTs()
+	e.UnverifiedSCTs = d.UnverifiedSCTs()
+	e.CAA = newReportCAA(d)
+	e.TLSA = newReportTLSA(d.DANE)
+	e.HTTP = newReportHTTP(d.HTTP)
+	e.NotBefore = formatTime(d.NotBefore)
+	e.NotAfter = formatTime(d.NotAfter)
+	e.Expiry = formatTime(d.Expiry())
+	for _, c := range d.Chain {
+		e.Chain = append(e.Chain, newReportCert(c))
+	}
+	for _, c := range d.VerifiedChain {
+		e.VerifiedChain = append(e.VerifiedChain, newReportCert(c))
+	}
+	return e
+}
+
+func newReportCert(c *x509.Certificate) reportCert {
+	info := NewCertInfo(c)
+	return reportCert{
+		Subject:            c.Subject.String(),
+		Issuer:             c.Issuer.String(),
+		Serial:             c.SerialNumber.Text(16),
+		NotBefore:          formatTime(c.NotBefore),
+		NotAfter:           formatTime(c.NotAfter),
+		KeyAlgorithm:       info.KeyAlgorithm,
+		KeySize:            info.KeySize,
+		Curve:              info.Curve,
+		SignatureAlgorithm: info.SignatureAlgorithm,
+		Version:            info.Version,
+		IsCA:               info.IsCA,
+		KeyUsage:           info.KeyUsage,
+		ExtKeyUsage:        info.ExtKeyUsage,
+	}
+}
+
+func reportEntries(domains []Response, queries []string) []reportEntry {
+	entries := []reportEntry{}
+	for _, group := range groupByEnv(domains, queries) {
+		for _, d := range group {
+			entries = append(entries, newReportEntry(d))
+		}
+	}
+	return entries
+}
+
+type jsonReport struct{}
+
+func (jsonReport) Write(w io.Writer, domains []Response, queries []string) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(reportEntries(domains, queries))
+}
+
+type yamlReport struct{}
+
+func (yamlReport) Write(w io.Writer, domains []Response, queries []string) error {
+	enc := yaml.NewEncoder(w)
+	enc.SetIndent(2)
+	if err := enc.Encode(reportEntries(domains, queries)); err != nil {
+		return err
+	}
+	return enc.Close()
+}
+
+type csvReport struct{}
+
+func (csvReport) Write(w io.Writer, domains []Response, queries []string) error {
+	out := csv.NewWriter(w)
+	out.Write([]string{
+		"environment", "endpoint", "domain", "port", "protocol", "address", "connect", "proxy", "server_name", "verify_name", "trust_store", "subject", "issuer", "serial",
+		"not_before", "not_after", "expiry", "san", "chain_length", "verified", "revocation", "stale_crl", "valid_scts", "unverified_scts", "tlsa", "caa", "tls_version", "cipher_suite", "alpn", "deprecated", "findings", "http_status", "hsts", "client_cert_requested", "attempts", "error", "error_class",
+	})
+	rows := []reportEntry{}
+	for _, e := range reportEntries(domains, queries) {
+		// One row per backend when every address of a hostname was probed
+		if len(e.Backends) > 0 {
+			rows = append(rows, e.Backends...)
+		} else {
+			rows = append(rows, e)
+		}
+	}
+	for _, e := range rows {
+		var httpStatus, hsts string
+		if e.HTTP != nil && e.HTTP.Status != 0 {
+			httpStatus, hsts = strconv.Itoa(e.HTTP.Status), e.HTTP.HSTS
+		}
+		out.Write([]string{
+			e.Environment, e.Endpoint, e.Domain, e.Port, e.Protocol, e.Address, e.Connect, e.Proxy, e.ServerName, e.VerifyName, e.TrustStore, e.Subject, e.Issuer, e.Serial,
+			e.NotBefore, e.NotAfter, e.Expiry, strings.Join(e.SAN, ";"), strconv.Itoa(len(e.Chain)), strconv.FormatBool(e.Verified), e.Revocation, strconv.FormatBool(e.StaleCRL), strconv.Itoa(e.ValidSCTs), strconv.Itoa(e.UnverifiedSCTs), tlsaColumn(e.TLSA), caaColumn(e.CAA), e.TLSVersion, e.CipherSuite, e.ALPN, strings.Join(e.Deprecated, ";"), strings.Join(findingMessages(e.Findings), ";"), httpStatus, hsts, strconv.FormatBool(e.ClientCertRequested), strconv.Itoa(e.Attempts), e.Error, e.ErrorClass,
+		})
+	}
+	out.Flush()
+	return out.Error()
+}
+
+// findingMessages flattens findings for single column outputs
+func findingMessages(findings []reportFinding) []string {
+	messages := make([]string, len(findings))
+	for k, f := range findings {
+		messages[k] = fmt.Sprintf("%s: %s: %s", f.Severity, f.Subject, f.Message)
+	}
+	return messages
+}
@@ -0,0 +1,166 @@
+package domains
+
+import (
+	"bytes"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"errors"
+	"flag"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+var update = flag.Bool("update", false, "update the golden files of testdata")
+
+// reportResponses returns a success, an error and an all-addresses response
+// with fixed certificates, across two environments
+func reportResponses(t *testing.T) []Response {
+	t.Helper()
+	notBefore := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	ca := newTestCert(t, &x509.Certificate{
+		Subject:               pkix.Name{CommonName: "Test CA", Organization: []string{"Test CA"}},
+		SerialNumber:          big.NewInt(1),
+		NotBefore:             notBefore,
+		NotAfter:              notBefore.AddDate(10, 0, 0),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign | x509.KeyUsageCRLSign,
+	}, nil)
+	leaf := func(serial int64, days int, names ...string) *x509.Certificate {
+		return newTestCert(t, &x509.Certificate{
+			Subject:      pkix.Name{CommonName: names[0]},
+			DNSNames:     names,
+			SerialNumber: big.NewInt(serial),
+			NotBefore:    notBefore,
+			NotAfter:     notBefore.AddDate(0, 0, days),
+			KeyUsage:     x509.KeyUsageDigitalSignature,
+			ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		}, ca).cert
+	}
+	success := func(env, host, address string, cert *x509.Certificate) Response {
+		return Response{
+			Environment:   env,
+			Domain:        host,
+			Port:          "443",
+			Protocol:      DefaultProtocol,
+			Address:       address,
+			ServerName:    host,
+			NotBefore:     cert.NotBefore,
+			NotAfter:      cert.NotAfter,
+			Issuer:        cert.Issuer,
+			SerialNumber:  cert.SerialNumber,
+			Subject:       cert.Subject,
+			SAN:           cert.DNSNames,
+			Chain:         []*x509.Certificate{cert, ca.cert},
+			VerifiedChain: []*x509.Certificate{cert, ca.cert},
+			Verified:      true,
+			TLSVersion:    tls.VersionTLS13,
+			CipherSuite:   tls.TLS_AES_128_GCM_SHA256,
+			ALPN:          "h2",
+			Attempts:      1,
+		}
+	}
+
+	www := success("prod", "www.example.test", "192.0.2.1", leaf(0x1001, 120, "www.example.test", "example.test"))
+	www.OCSPResponder = &OCSPResult{
+		Status:     RevocationGood,
+		ThisUpdate: notBefore.AddDate(0, 0, 30),
+		NextUpdate: notBefore.AddDate(0, 0, 37),
+		Responder:  "http://ocsp.example.test",
+	}
+	www.DANE = &DANEResult{Name: "_443._tcp.www.example.test", Records: []TLSARecord{
+		{Usage: 3, Selector: 1, MatchingType: 1, Data: "0123456789abcdef", Matched: true},
+	}}
+	www.CAA = &CAAResult{Domain: "example.test", Issue: []string{"ca.example.test"}}
+	www.Findings = []Finding{{Severity: FindingWarning, Subject: "www.example.test", Message: "certificate lifetime above 90 days"}}
+
+	down := Response{
+		Environment:   "prod",
+		Domain:        "down.example.test",
+		Port:          "8443",
+		Protocol:      DefaultProtocol,
+		Address:       "192.0.2.2",
+		Attempts:      2,
+		AttemptErrors: []error{errors.New("read: connection reset by peer"), errors.New("dial tcp 192.0.2.2:8443: connect: connection refused")},
+		Error:         errors.New("dial tcp 192.0.2.2:8443: connect: connection refused"),
+		ErrorCode:     ErrCodeRefused,
+	}
+
+	lbCert := leaf(0x2001, 60, "lb.example.test")
+	lb := success("staging", "lb.example.test", "198.51.100.1", lbCert)
+	lb.Backends = []Response{
+		success("staging", "lb.example.test", "198.51.100.1", lbCert),
+		success("staging", "lb.example.test", "198.51.100.2", leaf(0x2002, 30, "lb.example.test")),
+		{
+			Environment:   "staging",
+			Domain:        "lb.example.test",
+			Port:          "443",
+			Protocol:      DefaultProtocol,
+			Address:       "198.51.100.3",
+			Attempts:      1,
+			AttemptErrors: []error{errors.New("i/o timeout")},
+			Error:         errors.New("i/o timeout"),
+			ErrorCode:     ErrCodeTimeout,
+		},
+	}
+	return []Response{down, lb, www}
+}
+
+func TestReportWriters(t *testing.T) {
+	resps := reportResponses(t)
+	queries := []string{"prod", "staging"}
+	for _, format := range ReportFormats() {
+		t.Run(format, func(t *testing.T) {
+			w, err := NewReportWriter(format)
+			if err != nil {
+				t.Fatal(err)
+			}
+			var out bytes.Buffer
+			if err := w.Write(&out, resps, queries); err != nil {
+				t.Fatal(err)
+			}
+			golden := filepath.Join("testdata", "report."+format)
+			if *update {
+				if err := os.WriteFile(golden, out.Bytes(), 0644); err != nil {
+					t.Fatal(err)
+				}
+			}
+			want, err := os.ReadFile(golden)
+			if err != nil {
+				t.Fatalf("%v, run the tests with -update to create it", err)
+			}
+			if !bytes.Equal(out.Bytes(), want) {
+				t.Errorf("report differs from %s, run the tests with -update after checking the diff:\n%s", golden, out.String())
+			}
+		})
+	}
+
+	if _, err := NewReportWriter("html"); err == nil {
+		t.Error("html report format accepted")
+	}
+	if _, err := NewReportWriter("JSON"); err != nil {
+		t.Errorf("format names are not case insensitive: %v", err)
+	}
+}
+
+func TestReportFormatFromFile(t *testing.T) {
+	for file, want := range map[string]string{
+		"report.json":        "json",
+		"/tmp/report.JSON":   "json",
+		"report.yaml":        "yaml",
+		"report.yml":         "yaml",
+		"./out/report.csv":   "csv",
+		"report.md":          "markdown",
+		"report":             "markdown",
+		"report.json.txt":    "markdown",
+		"reports.d/latest.x": "markdown",
+	} {
+		if got := ReportFormatFromFile(file); got != want {
+			t.Errorf("ReportFormatFromFile(%q) = %s, want %s", file, got, want)
+		}
+	}
+}
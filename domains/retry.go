@@ -0,0 +1,42 @@
+package domains
+
+import (
+	"context"
+	"math/rand"
+	"time"
+)
+
+const (
+	DefaultBackoff = time.Second
+	maxBackoff     = 30 * time.Second
+)
+
+// isTransient tells whether a probe failure may go away on retry, such as
+// timeouts, resets or temporary DNS failures, as opposed to deterministic
+// ones like a certificate name mismatch or an unknown authority
+func isTransient(err error) bool {
+	return Classify(err).Transient()
+}
+
+// backoff waits before retry attempt n (starting at 1), using exponential
+// backoff with equal jitter: half of the delay is kept and the other half
+// randomized. It returns false if ctx ended first.
+func backoff(ctx context.Context, base time.Duration, n int) bool {
+	if base <= 0 {
+		base = DefaultBackoff
+	}
+	d := base << (n - 1)
+	if d > maxBackoff || d <= 0 {
+		d = maxBackoff
+	}
+	d = d/2 + time.Duration(rand.Int63n(int64(d/2)+1))
+
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
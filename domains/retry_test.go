@@ -0,0 +1,156 @@
+package domains
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// resetListener resets the first n connections it accepts and serves cert
+// on the next ones, counting every connection
+type resetListener struct {
+	net.Listener
+	n        int32
+	accepted atomic.Int32
+}
+
+func (l *resetListener) Accept() (net.Conn, error) {
+	for {
+		conn, err := l.Listener.Accept()
+		if err != nil {
+			return nil, err
+		}
+		if l.accepted.Add(1) > l.n {
+			return conn, nil
+		}
+		// Closing with a zero linger sends a RST instead of a FIN
+		conn.(*net.TCPConn).SetLinger(0)
+		conn.Close()
+	}
+}
+
+func TestProbeRetries(t *testing.T) {
+	ca := newTestCA(t, "Test CA")
+	cert := newTestLeaf(t, ca, "www.example.test").tlsCertificate(ca)
+
+	tests := []struct {
+		name    string
+		resets  int32
+		retries int
+		// want is the number of attempts
+		want    int
+		wantErr ErrorCode
+	}{
+		{"no failure", 0, 3, 1, ErrCodeNone},
+		{"recovered", 2, 3, 3, ErrCodeNone},
+		{"retries exhausted", 5, 2, 3, ErrCodeReset},
+		{"no retry", 1, 0, 1, ErrCodeReset},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l, err := net.Listen("tcp", "127.0.0.1:0")
+			if err != nil {
+				t.Fatal(err)
+			}
+			rl := &resetListener{Listener: l, n: tt.resets}
+			serveTLSLoop(t, rl, cert)
+			_, port, _ := net.SplitHostPort(l.Addr().String())
+
+			resp := probe(t, "www.example.test:"+port+"?connect=127.0.0.1", Options{
+				Retries:    tt.retries,
+				Backoff:    time.Millisecond,
+				TrustStore: testTrustStore(ca),
+			})
+			if resp.ErrorCode != tt.wantErr {
+				t.Fatalf("got error code %q (%v), want %q", resp.ErrorCode, resp.Error, tt.wantErr)
+			}
+			if resp.Attempts != tt.want || int(rl.accepted.Load()) != tt.want {
+				t.Errorf("got %d attempts and %d connections, want %d", resp.Attempts, rl.accepted.Load(), tt.want)
+			}
+			// The successful attempt leaves no error
+			wantErrors := tt.want
+			if tt.wantErr == ErrCodeNone {
+				wantErrors--
+			}
+			if len(resp.AttemptErrors) != wantErrors {
+				t.Errorf("got attempt errors %v, want %d", resp.AttemptErrors, wantErrors)
+			}
+			for _, err := range resp.AttemptErrors {
+				if !isTransient(err) {
+					t.Errorf("attempt error %v is not transient", err)
+				}
+			}
+		})
+	}
+}
+
+func TestProbePermanentErrorsNotRetried(t *testing.T) {
+	ca := newTestCA(t, "Test CA")
+	opts := Options{Retries: 3, Backoff: time.Millisecond, TrustStore: testTrustStore(ca)}
+
+	// A certificate from an unknown authority completes the handshake and
+	// fails verification only
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	other := newTestCA(t, "Other CA")
+	untrusted := &resetListener{Listener: l}
+	serveTLSLoop(t, untrusted, newTestLeaf(t, other, "www.example.test").tlsCertificate(other))
+	_, port, _ := net.SplitHostPort(l.Addr().String())
+	resp := probe(t, "www.example.test:"+port+"?connect=127.0.0.1", opts)
+	if resp.Verified || !errors.As(resp.VerifyError, new(x509.UnknownAuthorityError)) {
+		t.Fatalf("got verify error %v, want an unknown authority", resp.VerifyError)
+	}
+	if resp.Attempts != 1 || untrusted.accepted.Load() != 1 {
+		t.Errorf("got %d attempts and %d connections, want 1", resp.Attempts, untrusted.accepted.Load())
+	}
+
+	// A server without a certificate fails every handshake with an alert
+	l, err = net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	alerting := &resetListener{Listener: l}
+	serveTLSLoop(t, alerting, tls.Certificate{})
+	resp = probe(t, alerting.Addr().String(), opts)
+	if resp.ErrorCode.Transient() || resp.Error == nil {
+		t.Fatalf("got error code %q (%v), want a permanent failure", resp.ErrorCode, resp.Error)
+	}
+	if resp.Attempts != 1 || alerting.accepted.Load() != 1 || len(resp.AttemptErrors) != 1 {
+		t.Errorf("got %d attempts, %d connections and errors %v, want 1", resp.Attempts, alerting.accepted.Load(), resp.AttemptErrors)
+	}
+
+	// Nothing listening
+	addr := l.Addr().String()
+	l.Close()
+	resp = probe(t, addr, opts)
+	if resp.ErrorCode != ErrCodeRefused || resp.Attempts != 1 {
+		t.Errorf("got error code %q after %d attempts, want %q after 1", resp.ErrorCode, resp.Attempts, ErrCodeRefused)
+	}
+}
+
+func TestBackoff(t *testing.T) {
+	base := 10 * time.Millisecond
+	for n, want := range map[int]time.Duration{1: base, 2: 2 * base, 3: 4 * base} {
+		start := time.Now()
+		if !backoff(context.Background(), base, n) {
+			t.Fatalf("backoff %d interrupted", n)
+		}
+		// Equal jitter keeps at least half of the delay
+		if got := time.Since(start); got < want/2 {
+			t.Errorf("backoff %d waited %v, want at least %v", n, got, want/2)
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if backoff(ctx, time.Hour, 1) {
+		t.Error("backoff ignored the cancelled context")
+	}
+}
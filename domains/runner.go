@@ -0,0 +1,263 @@
+package domains
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// Query is the list of targets of an environment
+type Query struct {
+	Environment string
+	Targets     []string
+	// Options overrides the runner options for this environment
+	Options *Options
+}
+
+// LoadQueries builds the queries from target files and inline domain lists,
+// sorted by environment name
+func LoadQueries(files map[string]string, customList map[string][]string) ([]Query, error) {
+	queries := []Query{}
+	for env, target := range files {
+		expandedPath := os.ExpandEnv(target)
+		file, err := os.Open(expandedPath)
+		if err != nil {
+			return nil, fmt.Errorf("can't read %s targets: %w", env, err)
+		}
+		q := Query{Environment: env}
+		scanner := bufio.NewScanner(file)
+		for scanner.Scan() {
+			domain := scanner.Text()
+			if len(strings.TrimSpace(domain)) == 0 {
+				continue
+			}
+			q.Targets = append(q.Targets, domain)
+		}
+		file.Close()
+		if err := scanner.Err(); err != nil {
+			return nil, fmt.Errorf("can't read %s targets: %w", env, err)
+		}
+		queries = append(queries, q)
+	}
+	for env, target := range customList {
+		queries = append(queries, Query{Environment: env, Targets: target})
+	}
+	sort.Slice(queries, func(i, j int) bool {
+		return queries[i].Environment < queries[j].Environment
+	})
+	return queries, nil
+}
+
+const (
+	DefaultConcurrency = 50
+)
+
+// Limits bounds how hard a run hits the targets
+type Limits struct {
+	// Concurrency is the number of probes running at once
+	Concurrency int
+	// QPS caps the number of probes, including each backend of
+	// AllAddresses and each scan handshake, started per second, 0 disables it
+	QPS float64
+	// PerHost caps the concurrent probes to a single hostname and the
+	// concurrent connections to a single IP, 0 disables it
+	PerHost int
+}
+
+// limiter enforces the rate and per host limits on the probes and their
+// connections, shared by every probe of a run
+type limiter struct {
+	// throttle ticks QPS times per second, nil without rate limit
+	throttle *time.Ticker
+	perHost  int
+
+	mu    sync.Mutex
+	hosts map[string]chan struct{}
+}
+
+// wait blocks until the next tick of the throttle, it returns false when
+// ctx ended first. A nil limiter never blocks.
+func (l *limiter) wait(ctx context.Context) bool {
+	if l == nil || l.throttle == nil {
+		return ctx.Err() == nil
+	}
+	select {
+	case <-l.throttle.C:
+		return true
+	case <-ctx.Done():
+		return false
+	}
+}
+
+// acquire holds a slot of host until release is called, it returns false
+// when ctx ended first. A nil limiter never blocks.
+func (l *limiter) acquire(ctx context.Context, host string) (release func(), ok bool) {
+	if l == nil || l.perHost <= 0 {
+		return func() {}, true
+	}
+	l.mu.Lock()
+	slot, found := l.hosts[host]
+	if !found {
+		slot = make(chan struct{}, l.perHost)
+		l.hosts[host] = slot
+	}
+	l.mu.Unlock()
+	select {
+	case slot <- struct{}{}:
+		var once sync.Once
+		return func() { once.Do(func() { <-slot }) }, true
+	case <-ctx.Done():
+		return func() {}, false
+	}
+}
+
+// job is a single target to probe
+type job struct {
+	domain, env string
+	opts        Options
+}
+
+// Runner probes every target of a set of queries through a bounded worker
+// pool and streams the responses
+type Runner struct {
+	Queries []Query
+	Options Options
+	Limits  Limits
+
+	mu      sync.Mutex
+	active  map[string]int
+	limiter *limiter
+}
+
+func NewRunner(queries []Query, opts Options, limits Limits) *Runner {
+	if limits.Concurrency <= 0 {
+		limits.Concurrency = DefaultConcurrency
+	}
+	return &Runner{
+		Queries: queries,
+		Options: opts,
+		Limits:  limits,
+		active:  map[string]int{},
+		limiter: &limiter{perHost: limits.PerHost, hosts: map[string]chan struct{}{}},
+	}
+}
+
+// Environments returns the environment names in query order
+func (r *Runner) Environments() []string {
+	envs := make([]string, len(r.Queries))
+	for k, q := range r.Queries {
+		envs[k] = q.Environment
+	}
+	return envs
+}
+
+// Totals returns the number of targets per environment
+func (r *Runner) Totals() map[string]int {
+	totals := make(map[string]int, len(r.Queries))
+	for _, q := range r.Queries {
+		totals[q.Environment] += len(q.Targets)
+	}
+	return totals
+}
+
+// Active returns the number of probes in flight for an environment
+func (r *Runner) Active(env string) int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.active[env]
+}
+
+// Start launches the probes, the returned channel receives one response per
+// target and is closed once every probe completed. Cancelling ctx aborts
+// the in-flight probes and reports the remaining targets as cancelled.
+func (r *Runner) Start(ctx context.Context) <-chan Response {
+	var total int
+	for _, q := range r.Queries {
+		total += len(q.Targets)
+	}
+	// Buffered so probes never block on a consumer that stopped reading
+	out := make(chan Response, total)
+	jobs := make(chan job)
+
+	if r.Limits.QPS > 0 {
+		// Rates over a billion per second would truncate to a zero interval
+		interval := time.Duration(float64(time.Second) / r.Limits.QPS)
+		if interval <= 0 {
+			interval = time.Nanosecond
+		}
+		r.limiter.throttle = time.NewTicker(interval)
+	}
+
+	go func() {
+		for _, q := range r.Queries {
+			opts := r.Options
+			if q.Options != nil {
+				opts = *q.Options
+			}
+			opts.limiter = r.limiter
+			for _, domain := range q.Targets {
+				jobs <- job{domain: domain, env: q.Environment, opts: opts}
+			}
+		}
+		close(jobs)
+	}()
+
+	var wg sync.WaitGroup
+	workers := r.Limits.Concurrency
+	if workers > total {
+		workers = total
+	}
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := range jobs {
+				r.limiter.wait(ctx)
+				r.probe(ctx, j, out)
+			}
+		}()
+	}
+	go func() {
+		wg.Wait()
+		if r.limiter.throttle != nil {
+			r.limiter.throttle.Stop()
+		}
+		close(out)
+		log.Debug().Msg("All probes completed")
+	}()
+	return out
+}
+
+// probe runs a single job, holding a slot of its hostname when PerHost is
+// set. The connections also hold a slot of the IP they reach, so virtual
+// hosts sharing an address share its limit.
+func (r *Runner) probe(ctx context.Context, j job, out chan<- Response) {
+	if t, err := ParseTarget(j.domain); err == nil {
+		if t, err = t.withDefaults(j.opts); err == nil {
+			// IP targets are only limited by their connections
+			if host, _, _ := net.SplitHostPort(t.DialAddress()); net.ParseIP(host) == nil {
+				release, _ := r.limiter.acquire(ctx, host)
+				defer release()
+			}
+		}
+	}
+
+	r.mu.Lock()
+	r.active[j.env]++
+	r.mu.Unlock()
+	defer func() {
+		r.mu.Lock()
+		r.active[j.env]--
+		r.mu.Unlock()
+	}()
+
+	TestDomain(ctx, j.domain, j.env, j.opts, out)
+}
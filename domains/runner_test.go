@@ -0,0 +1,112 @@
+package domains
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"net"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestRunnerPerHostSharedAddress(t *testing.T) {
+	ca := newTestCA(t, "Test CA")
+	leaf := newTestLeaf(t, ca, "*.example.test")
+	l, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{Certificates: []tls.Certificate{leaf.tlsCertificate(ca)}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	var (
+		mu           sync.Mutex
+		active, peak int
+		connections  int
+	)
+	go func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				defer conn.Close()
+				mu.Lock()
+				active++
+				connections++
+				if active > peak {
+					peak = active
+				}
+				mu.Unlock()
+				// Delay the handshake so overlapping probes would show
+				time.Sleep(50 * time.Millisecond)
+				mu.Lock()
+				active--
+				mu.Unlock()
+				conn.(*tls.Conn).Handshake()
+			}()
+		}
+	}()
+
+	// Virtual hosts of the same address share its slot
+	var targets []string
+	for i := 0; i < 4; i++ {
+		targets = append(targets, fmt.Sprintf("vhost%d.example.test?connect=%s", i, l.Addr()))
+	}
+	runner := NewRunner([]Query{{Environment: "test", Targets: targets}},
+		Options{Timeout: 5 * time.Second, Proxy: ProxyDirect}, Limits{Concurrency: 4, PerHost: 1})
+	for resp := range runner.Start(context.Background()) {
+		if !resp.HasCert() {
+			t.Errorf("%s: %v", resp.Domain, resp.Error)
+		}
+	}
+	if connections != 4 || peak != 1 {
+		t.Errorf("got %d connections, %d at once, want 4 one at a time", connections, peak)
+	}
+}
+
+func TestLimiterReleasesOnClose(t *testing.T) {
+	l := &limiter{perHost: 1, hosts: map[string]chan struct{}{}}
+	release, ok := l.acquire(context.Background(), "192.0.2.1")
+	if !ok {
+		t.Fatal("first slot refused")
+	}
+	client, server := net.Pipe()
+	defer server.Close()
+	conn := &slotConn{Conn: client, release: release}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	if _, ok := l.acquire(ctx, "192.0.2.1"); ok {
+		t.Fatal("second slot granted while the connection is open")
+	}
+	conn.Close()
+	conn.Close()
+	if release, ok := l.acquire(context.Background(), "192.0.2.1"); !ok {
+		t.Fatal("slot not released on close")
+	} else {
+		release()
+	}
+}
+
+func TestRunnerScanWithinLimits(t *testing.T) {
+	ca := newTestCA(t, "Test CA")
+	leaf := newTestLeaf(t, ca, "scan.example.test")
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	serveTLSLoop(t, l, leaf.tlsCertificate(ca))
+
+	// The scan handshakes queue behind the connection slot of the probe
+	runner := NewRunner([]Query{{Environment: "test", Targets: []string{"scan.example.test?connect=" + l.Addr().String()}}},
+		Options{Timeout: 5 * time.Second, Proxy: ProxyDirect, Scan: true}, Limits{PerHost: 1, QPS: 1000})
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+	for resp := range runner.Start(ctx) {
+		if resp.Scan == nil || len(resp.Scan.Versions) != 2 {
+			t.Fatalf("got scan %+v (%v), want TLS 1.2 and 1.3", resp.Scan, resp.Error)
+		}
+	}
+}
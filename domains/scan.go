@@ -0,0 +1,145 @@
+package domains
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+)
+
+// scanVersions are the protocol versions tried by a scan, oldest first
+var scanVersions = []uint16{tls.VersionTLS10, tls.VersionTLS11, tls.VersionTLS12, tls.VersionTLS13}
+
+// ScanSuite is a cipher suite accepted for a protocol version
+type ScanSuite struct {
+	Version uint16
+	Suite   uint16
+}
+
+// ScanResult lists what an endpoint accepts. Suites only cover TLS 1.2 and
+// older, TLS 1.3 suites can't be restricted by the client, and are limited
+// to the ones implemented by crypto/tls.
+type ScanResult struct {
+	Versions []uint16
+	Suites   []ScanSuite
+}
+
+// VersionName returns the usual name of a TLS version
+func VersionName(v uint16) string {
+	if v == 0 {
+		return ""
+	}
+	return tls.VersionName(v)
+}
+
+// SuiteName returns the IANA name of a cipher suite
+func SuiteName(s uint16) string {
+	if s == 0 {
+		return ""
+	}
+	return tls.CipherSuiteName(s)
+}
+
+// DeprecatedVersion tells whether v is TLS 1.1 or older (RFC 8996)
+func DeprecatedVersion(v uint16) bool {
+	return v != 0 && v < tls.VersionTLS12
+}
+
+// WeakSuite tells whether s is one of the suites crypto/tls considers
+// insecure: RC4, 3DES, CBC with SHA-256 or RSA key exchange
+func WeakSuite(s uint16) bool {
+	for _, c := range tls.InsecureCipherSuites() {
+		if c.ID == s {
+			return true
+		}
+	}
+	return false
+}
+
+// Deprecated lists the deprecated protocol versions and weak cipher suites
+// negotiated by default or accepted during the scan
+func (i Response) Deprecated() []string {
+	var findings []string
+	seen := map[string]bool{}
+	add := func(f string) {
+		if !seen[f] {
+			seen[f] = true
+			findings = append(findings, f)
+		}
+	}
+	if DeprecatedVersion(i.TLSVersion) {
+		add(VersionName(i.TLSVersion))
+	}
+	if WeakSuite(i.CipherSuite) {
+		add(SuiteName(i.CipherSuite))
+	}
+	if i.Scan != nil {
+		for _, v := range i.Scan.Versions {
+			if DeprecatedVersion(v) {
+				add(VersionName(v))
+			}
+		}
+		for _, s := range i.Scan.Suites {
+			if WeakSuite(s.Suite) {
+				add(SuiteName(s.Suite))
+			}
+		}
+	}
+	return findings
+}
+
+// scan performs one handshake per protocol version, then one per cipher
+// suite of each accepted version up to TLS 1.2, about fifty at most per
+// endpoint. Each of them waits for the run throttle and holds the per host
+// slot of its IP.
+func scan(ctx context.Context, target Target, d *dialer, addr string, opts Options) *ScanResult {
+	result := &ScanResult{}
+	try := func(version uint16, suite uint16) bool {
+		cfg := tlsConfig(target, opts, &clientAuth{})
+		cfg.MinVersion, cfg.MaxVersion = version, version
+		if suite != 0 {
+			cfg.CipherSuites = []uint16{suite}
+		}
+		if !opts.limiter.wait(ctx) {
+			return false
+		}
+		conn, err := handshake(ctx, target, d, addr, opts.Timeout, cfg)
+		if err != nil {
+			return false
+		}
+		conn.Close()
+		return true
+	}
+
+	suites := append(tls.CipherSuites(), tls.InsecureCipherSuites()...)
+	for _, v := range scanVersions {
+		if ctx.Err() != nil {
+			break
+		}
+		if !try(v, 0) {
+			continue
+		}
+		result.Versions = append(result.Versions, v)
+		if v == tls.VersionTLS13 {
+			continue
+		}
+		for _, s := range suites {
+			if supportsVersion(s, v) && try(v, s.ID) {
+				result.Suites = append(result.Suites, ScanSuite{Version: v, Suite: s.ID})
+			}
+		}
+	}
+	return result
+}
+
+func supportsVersion(s *tls.CipherSuite, v uint16) bool {
+	for _, sv := range s.SupportedVersions {
+		if sv == v {
+			return true
+		}
+	}
+	return false
+}
+
+func (s ScanSuite) String() string {
+	return fmt.Sprintf("%s %s", VersionName(s.Version), SuiteName(s.Suite))
+}
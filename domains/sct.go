@@ -0,0 +1,344 @@
+package domains
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"golang.org/x/crypto/cryptobyte"
+	cbasn1 "golang.org/x/crypto/cryptobyte/asn1"
+	"golang.org/x/crypto/ocsp"
+)
+
+// SCT sources
+const (
+	SCTFromCertificate = "certificate"
+	SCTFromTLS         = "tls"
+	SCTFromOCSP        = "ocsp"
+)
+
+var (
+	// oidSCTList is the certificate extension embedding SCTs (RFC 6962)
+	oidSCTList = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 11129, 2, 4, 2}
+	// oidOCSPSCTList is the OCSP single extension carrying SCTs
+	oidOCSPSCTList = asn1.ObjectIdentifier{1, 3, 6, 1, 4, 1, 11129, 2, 4, 5}
+)
+
+// SCT is a signed certificate timestamp, the promise of a CT log to
+// include the certificate
+type SCT struct {
+	Source    string
+	LogID     string
+	Timestamp time.Time
+	// Log is the description of the log in the log list, empty when the log
+	// is unknown or no list is configured
+	Log string
+	// Verified is set when the signature was checked against the log key
+	Verified bool
+	Error    error
+
+	raw sct
+}
+
+// sct is the TLS encoding of an SCT, version 1 only
+type sct struct {
+	logID      [32]byte
+	timestamp  uint64
+	extensions []byte
+	hashAlg    uint8
+	sigAlg     uint8
+	signature  []byte
+}
+
+// CTLog is a log of the log list
+type CTLog struct {
+	Description string
+	Key         crypto.PublicKey
+}
+
+// CTLogList holds the known CT logs by log ID
+type CTLogList struct {
+	File string
+	Logs map[[32]byte]CTLog
+}
+
+// LoadCTLogList reads a log list in the v3 JSON format published at
+// https://www.gstatic.com/ct/log_list/v3/log_list.json
+func LoadCTLogList(file string) (*CTLogList, error) {
+	data, err := os.ReadFile(os.ExpandEnv(file))
+	if err != nil {
+		return nil, fmt.Errorf("can't read CT log list: %w", err)
+	}
+	var list struct {
+		Operators []struct {
+			Logs []struct {
+				Description string `json:"description"`
+				Key         string `json:"key"`
+			} `json:"logs"`
+		} `json:"operators"`
+	}
+	if err := json.Unmarshal(data, &list); err != nil {
+		return nil, fmt.Errorf("invalid CT log list %s: %w", file, err)
+	}
+	logs := &CTLogList{File: file, Logs: map[[32]byte]CTLog{}}
+	for _, op := range list.Operators {
+		for _, l := range op.Logs {
+			der, err := base64.StdEncoding.DecodeString(l.Key)
+			if err != nil {
+				return nil, fmt.Errorf("invalid key of CT log %s: %w", l.Description, err)
+			}
+			key, err := x509.ParsePKIXPublicKey(der)
+			if err != nil {
+				return nil, fmt.Errorf("invalid key of CT log %s: %w", l.Description, err)
+			}
+			// The log ID is the hash of its key, the listed one is not trusted
+			logs.Logs[sha256.Sum256(der)] = CTLog{Description: l.Description, Key: key}
+		}
+	}
+	if len(logs.Logs) == 0 {
+		return nil, fmt.Errorf("no log in CT log list %s", file)
+	}
+	return logs, nil
+}
+
+// ValidSCTs returns the number of SCTs issued by a known log with a valid
+// signature, always 0 without a log list
+func (i Response) ValidSCTs() int {
+	n := 0
+	for _, s := range i.SCTs {
+		if s.Verified {
+			n++
+		}
+	}
+	return n
+}
+
+// UnverifiedSCTs returns the number of SCTs decoded without a log list to
+// check their signature
+func (i Response) UnverifiedSCTs() int {
+	n := 0
+	for _, s := range i.SCTs {
+		if s.Error == nil && !s.Verified {
+			n++
+		}
+	}
+	return n
+}
+
+// SCTSources lists the distinct sources SCTs were found in
+func (i Response) SCTSources() []string {
+	var sources []string
+	seen := map[string]bool{}
+	for _, s := range i.SCTs {
+		if !seen[s.Source] {
+			seen[s.Source] = true
+			sources = append(sources, s.Source)
+		}
+	}
+	return sources
+}
+
+// extractSCTs collects the SCTs of leaf from the certificate extension, the
+// TLS extension and the stapled OCSP response. Their signatures are checked
+// when logs is set, which requires the issuer for embedded SCTs.
+func extractSCTs(leaf, issuer *x509.Certificate, tlsSCTs [][]byte, stapled []byte, logs *CTLogList) []SCT {
+	var scts []SCT
+	for _, ext := range leaf.Extensions {
+		if ext.Id.Equal(oidSCTList) {
+			scts = append(scts, parseSCTList(ext.Value, SCTFromCertificate)...)
+		}
+	}
+	for _, raw := range tlsSCTs {
+		scts = append(scts, parseSCT(raw, SCTFromTLS))
+	}
+	if len(stapled) > 0 {
+		// The staple validity is reported by parseOCSP
+		if resp, err := ocsp.ParseResponse(stapled, nil); err == nil {
+			for _, ext := range resp.Extensions {
+				if ext.Id.Equal(oidOCSPSCTList) {
+					scts = append(scts, parseSCTList(ext.Value, SCTFromOCSP)...)
+				}
+			}
+		}
+	}
+	if logs != nil {
+		for k := range scts {
+			scts[k].verify(leaf, issuer, logs)
+		}
+	}
+	return scts
+}
+
+// parseSCTList reads a DER octet string wrapping a TLS encoded SCT list
+func parseSCTList(value []byte, source string) []SCT {
+	var raw []byte
+	if rest, err := asn1.Unmarshal(value, &raw); err != nil || len(rest) > 0 {
+		return []SCT{{Source: source, Error: errors.New("invalid SCT list encoding")}}
+	}
+	var list, entry cryptobyte.String
+	input := cryptobyte.String(raw)
+	if !input.ReadUint16LengthPrefixed(&list) || !input.Empty() {
+		return []SCT{{Source: source, Error: errors.New("invalid SCT list encoding")}}
+	}
+	var scts []SCT
+	for !list.Empty() {
+		if !list.ReadUint16LengthPrefixed(&entry) {
+			return append(scts, SCT{Source: source, Error: errors.New("invalid SCT list encoding")})
+		}
+		scts = append(scts, parseSCT(entry, source))
+	}
+	return scts
+}
+
+// parseSCT decodes a single TLS encoded SCT
+func parseSCT(raw []byte, source string) SCT {
+	s := SCT{Source: source}
+	var (
+		version    uint8
+		logID      []byte
+		extensions cryptobyte.String
+		signature  cryptobyte.String
+	)
+	input := cryptobyte.String(raw)
+	if !input.ReadUint8(&version) {
+		s.Error = errors.New("invalid SCT encoding")
+		return s
+	}
+	if version != 0 {
+		s.Error = fmt.Errorf("unsupported SCT version %d", version+1)
+		return s
+	}
+	if !input.ReadBytes(&logID, 32) || !input.ReadUint64(&s.raw.timestamp) ||
+		!input.ReadUint16LengthPrefixed(&extensions) || !input.ReadUint8(&s.raw.hashAlg) ||
+		!input.ReadUint8(&s.raw.sigAlg) || !input.ReadUint16LengthPrefixed(&signature) || !input.Empty() {
+		s.Error = errors.New("invalid SCT encoding")
+		return s
+	}
+	copy(s.raw.logID[:], logID)
+	s.raw.extensions = extensions
+	s.raw.signature = signature
+	s.LogID = hex.EncodeToString(logID)
+	s.Timestamp = time.UnixMilli(int64(s.raw.timestamp)).UTC()
+	return s
+}
+
+// verify checks the SCT signature with the key of its log
+func (s *SCT) verify(leaf, issuer *x509.Certificate, logs *CTLogList) {
+	if s.Error != nil {
+		return
+	}
+	ctLog, ok := logs.Logs[s.raw.logID]
+	if !ok {
+		s.Error = errors.New("SCT from a log missing from the log list")
+		return
+	}
+	s.Log = ctLog.Description
+	if s.raw.hashAlg != 4 {
+		s.Error = fmt.Errorf("unsupported SCT hash algorithm %d", s.raw.hashAlg)
+		return
+	}
+
+	// digitally-signed struct of RFC 6962 section 3.2
+	var b cryptobyte.Builder
+	b.AddUint8(0) // v1
+	b.AddUint8(0) // certificate_timestamp
+	b.AddUint64(s.raw.timestamp)
+	if s.Source == SCTFromCertificate {
+		if issuer == nil {
+			s.Error = errors.New("issuer certificate unknown, can't check embedded SCT")
+			return
+		}
+		tbs, err := precertTBS(leaf.RawTBSCertificate)
+		if err != nil {
+			s.Error = err
+			return
+		}
+		keyHash := sha256.Sum256(issuer.RawSubjectPublicKeyInfo)
+		b.AddUint16(1) // precert_entry
+		b.AddBytes(keyHash[:])
+		b.AddUint24LengthPrefixed(func(b *cryptobyte.Builder) { b.AddBytes(tbs) })
+	} else {
+		b.AddUint16(0) // x509_entry
+		b.AddUint24LengthPrefixed(func(b *cryptobyte.Builder) { b.AddBytes(leaf.Raw) })
+	}
+	b.AddUint16LengthPrefixed(func(b *cryptobyte.Builder) { b.AddBytes(s.raw.extensions) })
+	signed, err := b.Bytes()
+	if err != nil {
+		s.Error = err
+		return
+	}
+	digest := sha256.Sum256(signed)
+
+	switch key := ctLog.Key.(type) {
+	case *ecdsa.PublicKey:
+		ok = s.raw.sigAlg == 3 && ecdsa.VerifyASN1(key, digest[:], s.raw.signature)
+	case *rsa.PublicKey:
+		ok = s.raw.sigAlg == 1 && rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], s.raw.signature) == nil
+	default:
+		ok = false
+	}
+	if !ok {
+		s.Error = errors.New("invalid SCT signature")
+		return
+	}
+	s.Verified = true
+}
+
+// precertTBS rebuilds the TBSCertificate the log signed, which is the one
+// of the certificate without the SCT list extension
+func precertTBS(raw []byte) ([]byte, error) {
+	invalid := errors.New("invalid TBSCertificate, can't check embedded SCT")
+	var tbs, field cryptobyte.String
+	input := cryptobyte.String(raw)
+	if !input.ReadASN1(&tbs, cbasn1.SEQUENCE) {
+		return nil, invalid
+	}
+	extTag := cbasn1.Tag(3).Constructed().ContextSpecific()
+
+	var b cryptobyte.Builder
+	b.AddASN1(cbasn1.SEQUENCE, func(b *cryptobyte.Builder) {
+		for !tbs.Empty() {
+			var tag cbasn1.Tag
+			if !tbs.ReadAnyASN1Element(&field, &tag) {
+				b.SetError(invalid)
+				return
+			}
+			if tag != extTag {
+				b.AddBytes(field)
+				continue
+			}
+			var explicit, exts, ext cryptobyte.String
+			if !field.ReadASN1(&explicit, extTag) || !explicit.ReadASN1(&exts, cbasn1.SEQUENCE) {
+				b.SetError(invalid)
+				return
+			}
+			b.AddASN1(extTag, func(b *cryptobyte.Builder) {
+				b.AddASN1(cbasn1.SEQUENCE, func(b *cryptobyte.Builder) {
+					for !exts.Empty() {
+						if !exts.ReadASN1Element(&ext, cbasn1.SEQUENCE) {
+							b.SetError(invalid)
+							return
+						}
+						element, body := ext, cryptobyte.String(nil)
+						var oid asn1.ObjectIdentifier
+						if element.ReadASN1(&body, cbasn1.SEQUENCE) && body.ReadASN1ObjectIdentifier(&oid) && oid.Equal(oidSCTList) {
+							continue
+						}
+						b.AddBytes(ext)
+					}
+				})
+			})
+		}
+	})
+	return b.Bytes()
+}
@@ -0,0 +1,302 @@
+package domains
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"golang.org/x/crypto/cryptobyte"
+	"golang.org/x/crypto/ocsp"
+)
+
+func TestSCTCountFindings(t *testing.T) {
+	ca := newTestCA(t, "Test CA")
+	leaf := newTestLeaf(t, ca, "ct.example.test")
+	unverified := SCT{Source: SCTFromCertificate, Timestamp: time.Now()}
+	verified := SCT{Source: SCTFromCertificate, Timestamp: time.Now(), Verified: true}
+	unknown := SCT{Source: SCTFromTLS, Timestamp: time.Now(), Error: errors.New("unknown log")}
+
+	tests := []struct {
+		name               string
+		scts               []SCT
+		valid, notVerified int
+		finding            string
+	}{
+		{"no log list", []SCT{unverified, unverified}, 0, 2, ""},
+		{"no log list shortage", []SCT{unverified}, 0, 1, "1 unverified SCTs, 2 required"},
+		{"verified", []SCT{verified, verified}, 2, 0, ""},
+		{"unknown log", []SCT{verified, unknown}, 1, 0, "1 valid SCTs, 2 required"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp := Response{
+				Chain:      []*x509.Certificate{leaf.cert, ca.cert},
+				Verified:   true,
+				TrustStore: SystemTrustStore,
+				SCTs:       tt.scts,
+			}
+			if resp.ValidSCTs() != tt.valid || resp.UnverifiedSCTs() != tt.notVerified {
+				t.Errorf("got %d valid and %d unverified, want %d and %d", resp.ValidSCTs(), resp.UnverifiedSCTs(), tt.valid, tt.notVerified)
+			}
+			var got string
+			for _, f := range resp.evaluate() {
+				if strings.Contains(f.Message, "SCTs") {
+					got = f.Message
+				}
+			}
+			if got != tt.finding {
+				t.Errorf("got finding %q, want %q", got, tt.finding)
+			}
+		})
+	}
+}
+
+// testCTLog is a CT log signing SCTs with its own key
+type testCTLog struct {
+	key *ecdsa.PrivateKey
+	id  [32]byte
+}
+
+func newTestCTLog(t *testing.T) *testCTLog {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testCTLog{key: key, id: sha256.Sum256(der)}
+}
+
+// sign returns the TLS encoded SCT of the log for a signed entry, built
+// after RFC 6962 section 3.2
+func (l *testCTLog) sign(t *testing.T, timestamp time.Time, entry func(b *cryptobyte.Builder)) []byte {
+	t.Helper()
+	ms := uint64(timestamp.UnixMilli())
+	var signed cryptobyte.Builder
+	signed.AddUint8(0)
+	signed.AddUint8(0)
+	signed.AddUint64(ms)
+	entry(&signed)
+	signed.AddUint16(0) // no extensions
+	digest := sha256.Sum256(signed.BytesOrPanic())
+	signature, err := ecdsa.SignASN1(rand.Reader, l.key, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var b cryptobyte.Builder
+	b.AddUint8(0)
+	b.AddBytes(l.id[:])
+	b.AddUint64(ms)
+	b.AddUint16(0)
+	b.AddUint8(4) // sha256
+	b.AddUint8(3) // ecdsa
+	b.AddUint16LengthPrefixed(func(b *cryptobyte.Builder) { b.AddBytes(signature) })
+	return b.BytesOrPanic()
+}
+
+// x509Entry signs the SCT of a final certificate, sent in TLS or OCSP
+func (l *testCTLog) x509Entry(t *testing.T, leaf *x509.Certificate) []byte {
+	return l.sign(t, time.Now(), func(b *cryptobyte.Builder) {
+		b.AddUint16(0)
+		b.AddUint24LengthPrefixed(func(b *cryptobyte.Builder) { b.AddBytes(leaf.Raw) })
+	})
+}
+
+// sctList returns the DER octet string wrapping a TLS encoded SCT list
+func sctList(t *testing.T, scts ...[]byte) []byte {
+	t.Helper()
+	var b cryptobyte.Builder
+	b.AddUint16LengthPrefixed(func(b *cryptobyte.Builder) {
+		for _, s := range scts {
+			b.AddUint16LengthPrefixed(func(b *cryptobyte.Builder) { b.AddBytes(s) })
+		}
+	})
+	der, err := asn1.Marshal(b.BytesOrPanic())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return der
+}
+
+// newPrecertLeaf issues a leaf embedding the SCTs signed by logs over its
+// precertificate, returning the precertificate TBS too
+func newPrecertLeaf(t *testing.T, ca *testCert, logs ...*testCTLog) (*x509.Certificate, []byte) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(42),
+		Subject:      pkix.Name{CommonName: "ct.example.test"},
+		DNSNames:     []string{"ct.example.test"},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(24 * time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	create := func() *x509.Certificate {
+		der, err := x509.CreateCertificate(rand.Reader, tmpl, ca.cert, &key.PublicKey, ca.key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cert, err := x509.ParseCertificate(der)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return cert
+	}
+	// The SCT list comes last, so dropping it gives back the TBS signed
+	tbs := create().RawTBSCertificate
+	keyHash := sha256.Sum256(ca.cert.RawSubjectPublicKeyInfo)
+	var scts [][]byte
+	for _, l := range logs {
+		scts = append(scts, l.sign(t, time.Now(), func(b *cryptobyte.Builder) {
+			b.AddUint16(1)
+			b.AddBytes(keyHash[:])
+			b.AddUint24LengthPrefixed(func(b *cryptobyte.Builder) { b.AddBytes(tbs) })
+		}))
+	}
+	tmpl.ExtraExtensions = []pkix.Extension{{Id: oidSCTList, Value: sctList(t, scts...)}}
+	return create(), tbs
+}
+
+// writeCTLogList writes a v3 log list of logs and loads it
+func writeCTLogList(t *testing.T, logs ...*testCTLog) *CTLogList {
+	t.Helper()
+	type log struct {
+		Description string `json:"description"`
+		Key         string `json:"key"`
+	}
+	var list struct {
+		Operators []struct {
+			Logs []log `json:"logs"`
+		} `json:"operators"`
+	}
+	list.Operators = make([]struct {
+		Logs []log `json:"logs"`
+	}, 1)
+	for k, l := range logs {
+		der, err := x509.MarshalPKIXPublicKey(&l.key.PublicKey)
+		if err != nil {
+			t.Fatal(err)
+		}
+		list.Operators[0].Logs = append(list.Operators[0].Logs, log{Description: fmt.Sprintf("Test log %d", k+1), Key: base64.StdEncoding.EncodeToString(der)})
+	}
+	data, err := json.Marshal(list)
+	if err != nil {
+		t.Fatal(err)
+	}
+	file := filepath.Join(t.TempDir(), "log_list.json")
+	if err := os.WriteFile(file, data, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	logList, err := LoadCTLogList(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return logList
+}
+
+func TestPrecertTBS(t *testing.T) {
+	ca := newTestCA(t, "Test CA")
+	leaf, tbs := newPrecertLeaf(t, ca, newTestCTLog(t))
+	got, err := precertTBS(leaf.RawTBSCertificate)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Equal(got, tbs) {
+		t.Error("TBSCertificate without the SCT list differs from the precertificate one")
+	}
+	if _, err := precertTBS([]byte{0x30, 0x03, 0x02}); err == nil {
+		t.Error("invalid TBSCertificate accepted")
+	}
+}
+
+func TestExtractSCTs(t *testing.T) {
+	ca := newTestCA(t, "Test CA")
+	known, other := newTestCTLog(t), newTestCTLog(t)
+	logs := writeCTLogList(t, known)
+	leaf, _ := newPrecertLeaf(t, ca, known, other)
+
+	tlsSCT := known.x509Entry(t, leaf)
+	tampered := known.x509Entry(t, leaf)
+	tampered[len(tampered)-1] ^= 0xff
+	stapled, err := ocsp.CreateResponse(ca.cert, ca.cert, ocsp.Response{
+		Status:          ocsp.Good,
+		SerialNumber:    leaf.SerialNumber,
+		ThisUpdate:      time.Now().Add(-time.Hour),
+		NextUpdate:      time.Now().Add(time.Hour),
+		ExtraExtensions: []pkix.Extension{{Id: oidOCSPSCTList, Value: sctList(t, known.x509Entry(t, leaf))}},
+	}, ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	scts := extractSCTs(leaf, ca.cert, [][]byte{tlsSCT, tampered}, stapled, logs)
+	want := []struct {
+		source string
+		err    string
+	}{
+		{SCTFromCertificate, ""},
+		{SCTFromCertificate, "missing from the log list"},
+		{SCTFromTLS, ""},
+		{SCTFromTLS, "invalid SCT signature"},
+		{SCTFromOCSP, ""},
+	}
+	if len(scts) != len(want) {
+		t.Fatalf("got %d SCTs, want %d", len(scts), len(want))
+	}
+	for k, w := range want {
+		s := scts[k]
+		if s.Source != w.source {
+			t.Errorf("SCT %d: got source %s, want %s", k, s.Source, w.source)
+		}
+		if w.err == "" {
+			if !s.Verified || s.Error != nil || s.Log != "Test log 1" {
+				t.Errorf("SCT %d from %s: got verified %v (%v) by %q", k, s.Source, s.Verified, s.Error, s.Log)
+			}
+		} else if s.Verified || s.Error == nil || !strings.Contains(s.Error.Error(), w.err) {
+			t.Errorf("SCT %d from %s: got verified %v (%v), want %q", k, s.Source, s.Verified, s.Error, w.err)
+		}
+	}
+	resp := Response{SCTs: scts}
+	if resp.ValidSCTs() != 3 || resp.UnverifiedSCTs() != 0 {
+		t.Errorf("got %d valid and %d unverified", resp.ValidSCTs(), resp.UnverifiedSCTs())
+	}
+
+	// Without a log list the SCTs are only decoded
+	scts = extractSCTs(leaf, ca.cert, [][]byte{tlsSCT}, nil, nil)
+	if len(scts) != 3 || scts[2].Verified || scts[2].Error != nil || scts[2].LogID != hex.EncodeToString(known.id[:]) || time.Since(scts[2].Timestamp) > time.Minute {
+		t.Errorf("got %+v without a log list", scts)
+	}
+
+	// Embedded SCTs are signed over the issuer key
+	if scts := extractSCTs(leaf, nil, nil, nil, logs); len(scts) != 2 || scts[0].Error == nil {
+		t.Errorf("got %+v without the issuer, want an error", scts)
+	}
+
+	if s := parseSCT(tlsSCT[:20], SCTFromTLS); s.Error == nil {
+		t.Error("truncated SCT accepted")
+	}
+}
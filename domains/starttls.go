@@ -0,0 +1,277 @@
+package domains
+
+import (
+	"bytes"
+	"encoding/asn1"
+	"encoding/binary"
+	"encoding/xml"
+	"fmt"
+	"io"
+	"net"
+	"net/textproto"
+	"strings"
+)
+
+// protocol describes how a scheme reaches the TLS handshake
+type protocol struct {
+	port     string
+	startTLS func(conn net.Conn, host string) error
+}
+
+// protocols maps target schemes to their default port and STARTTLS upgrade.
+// Schemes with a nil startTLS speak TLS on connect.
+var protocols = map[string]protocol{
+	"https":      {port: "443"},
+	"smtps":      {port: "465"},
+	"imaps":      {port: "993"},
+	"pop3s":      {port: "995"},
+	"ldaps":      {port: "636"},
+	"smtp":       {port: "25", startTLS: startTLSSMTP},
+	"submission": {port: "587", startTLS: startTLSSMTP},
+	"imap":       {port: "143", startTLS: startTLSIMAP},
+	"pop3":       {port: "110", startTLS: startTLSPOP3},
+	"ftp":        {port: "21", startTLS: startTLSFTP},
+	"ldap":       {port: "389", startTLS: startTLSLDAP},
+	"xmpp":       {port: "5222", startTLS: startTLSXMPP},
+	"postgres":   {port: "5432", startTLS: startTLSPostgres},
+	"postgresql": {port: "5432", startTLS: startTLSPostgres},
+}
+
+func startTLSSMTP(conn net.Conn, host string) error {
+	tp := textproto.NewConn(conn)
+	if _, _, err := tp.ReadResponse(220); err != nil {
+		return fmt.Errorf("smtp greeting: %w", err)
+	}
+	if err := tp.PrintfLine("EHLO %s", ehloName(conn)); err != nil {
+		return err
+	}
+	_, msg, err := tp.ReadResponse(250)
+	if err != nil {
+		return fmt.Errorf("smtp EHLO: %w", err)
+	}
+	if !strings.Contains(strings.ToUpper(msg), "STARTTLS") {
+		return fmt.Errorf("smtp server does not advertise STARTTLS")
+	}
+	if err := tp.PrintfLine("STARTTLS"); err != nil {
+		return err
+	}
+	if _, _, err := tp.ReadResponse(220); err != nil {
+		return fmt.Errorf("smtp STARTTLS: %w", err)
+	}
+	return nil
+}
+
+// ehloName returns the address literal of the local end of conn (RFC 5321
+// section 4.1.3), the checker having no FQDN of its own
+func ehloName(conn net.Conn) string {
+	tcpAddr, ok := conn.LocalAddr().(*net.TCPAddr)
+	switch {
+	case !ok:
+		return "[127.0.0.1]"
+	case tcpAddr.IP.To4() != nil:
+		return "[" + tcpAddr.IP.String() + "]"
+	}
+	return "[IPv6:" + tcpAddr.IP.String() + "]"
+}
+
+func startTLSIMAP(conn net.Conn, host string) error {
+	tp := textproto.NewConn(conn)
+	line, err := tp.ReadLine()
+	if err != nil {
+		return fmt.Errorf("imap greeting: %w", err)
+	}
+	if !strings.HasPrefix(line, "* OK") {
+		return fmt.Errorf("imap greeting: %s", line)
+	}
+	if err := tp.PrintfLine("a001 STARTTLS"); err != nil {
+		return err
+	}
+	for {
+		line, err = tp.ReadLine()
+		if err != nil {
+			return fmt.Errorf("imap STARTTLS: %w", err)
+		}
+		// Skip untagged responses until our tagged completion
+		if !strings.HasPrefix(line, "a001 ") {
+			continue
+		}
+		if !strings.HasPrefix(line, "a001 OK") {
+			return fmt.Errorf("imap STARTTLS: %s", line)
+		}
+		return nil
+	}
+}
+
+func startTLSPOP3(conn net.Conn, host string) error {
+	tp := textproto.NewConn(conn)
+	line, err := tp.ReadLine()
+	if err != nil {
+		return fmt.Errorf("pop3 greeting: %w", err)
+	}
+	if !strings.HasPrefix(line, "+OK") {
+		return fmt.Errorf("pop3 greeting: %s", line)
+	}
+	if err := tp.PrintfLine("STLS"); err != nil {
+		return err
+	}
+	line, err = tp.ReadLine()
+	if err != nil {
+		return fmt.Errorf("pop3 STLS: %w", err)
+	}
+	if !strings.HasPrefix(line, "+OK") {
+		return fmt.Errorf("pop3 STLS: %s", line)
+	}
+	return nil
+}
+
+func startTLSFTP(conn net.Conn, host string) error {
+	tp := textproto.NewConn(conn)
+	if _, _, err := tp.ReadResponse(220); err != nil {
+		return fmt.Errorf("ftp greeting: %w", err)
+	}
+	if err := tp.PrintfLine("AUTH TLS"); err != nil {
+		return err
+	}
+	if _, _, err := tp.ReadResponse(234); err != nil {
+		return fmt.Errorf("ftp AUTH TLS: %w", err)
+	}
+	return nil
+}
+
+// ldapStartTLSOID is the StartTLS extended operation name from RFC 4511
+const ldapStartTLSOID = "1.3.6.1.4.1.1466.20037"
+
+func startTLSLDAP(conn net.Conn, host string) error {
+	// LDAPMessage { messageID 1, ExtendedRequest [APPLICATION 23] { requestName [0] OID } }
+	name := []byte(ldapStartTLSOID)
+	op := append([]byte{0x80, byte(len(name))}, name...)
+	op = append([]byte{0x77, byte(len(op))}, op...)
+	msg := append([]byte{0x02, 0x01, 0x01}, op...)
+	msg = append([]byte{0x30, byte(len(msg))}, msg...)
+	if _, err := conn.Write(msg); err != nil {
+		return err
+	}
+
+	raw, err := readBER(conn)
+	if err != nil {
+		return fmt.Errorf("ldap StartTLS: %w", err)
+	}
+	var envelope asn1.RawValue
+	if _, err := asn1.Unmarshal(raw, &envelope); err != nil {
+		return fmt.Errorf("ldap StartTLS: %w", err)
+	}
+	var msgID, resp asn1.RawValue
+	rest, err := asn1.Unmarshal(envelope.Bytes, &msgID)
+	if err != nil {
+		return fmt.Errorf("ldap StartTLS: %w", err)
+	}
+	if _, err := asn1.Unmarshal(rest, &resp); err != nil {
+		return fmt.Errorf("ldap StartTLS: %w", err)
+	}
+	// ExtendedResponse is [APPLICATION 24] and starts with the resultCode
+	if resp.Class != asn1.ClassApplication || resp.Tag != 24 {
+		return fmt.Errorf("ldap StartTLS: unexpected response tag %d", resp.Tag)
+	}
+	var code asn1.RawValue
+	if _, err := asn1.Unmarshal(resp.Bytes, &code); err != nil {
+		return fmt.Errorf("ldap StartTLS: %w", err)
+	}
+	if len(code.Bytes) != 1 || code.Bytes[0] != 0 {
+		return fmt.Errorf("ldap StartTLS: server returned result code %v", code.Bytes)
+	}
+	return nil
+}
+
+// readBER reads exactly one BER encoded element from r
+func readBER(r io.Reader) ([]byte, error) {
+	hdr := make([]byte, 2)
+	if _, err := io.ReadFull(r, hdr); err != nil {
+		return nil, err
+	}
+	length := int(hdr[1])
+	if hdr[1]&0x80 != 0 {
+		n := int(hdr[1] & 0x7f)
+		if n == 0 || n > 4 {
+			return nil, fmt.Errorf("unsupported BER length")
+		}
+		ext := make([]byte, n)
+		if _, err := io.ReadFull(r, ext); err != nil {
+			return nil, err
+		}
+		hdr = append(hdr, ext...)
+		length = 0
+		for _, b := range ext {
+			length = length<<8 | int(b)
+		}
+	}
+	body := make([]byte, length)
+	if _, err := io.ReadFull(r, body); err != nil {
+		return nil, err
+	}
+	return append(hdr, body...), nil
+}
+
+func startTLSXMPP(conn net.Conn, host string) error {
+	var to strings.Builder
+	xml.EscapeText(&to, []byte(host))
+	header := fmt.Sprintf("<?xml version='1.0'?><stream:stream to='%s' xmlns='jabber:client' "+
+		"xmlns:stream='http://etherx.jabber.org/streams' version='1.0'>", to.String())
+	if _, err := io.WriteString(conn, header); err != nil {
+		return err
+	}
+	features, err := readUntil(conn, "</stream:features>")
+	if err != nil {
+		return fmt.Errorf("xmpp stream features: %w", err)
+	}
+	if !bytes.Contains(features, []byte("urn:ietf:params:xml:ns:xmpp-tls")) {
+		return fmt.Errorf("xmpp server does not advertise STARTTLS")
+	}
+	if _, err := io.WriteString(conn, "<starttls xmlns='urn:ietf:params:xml:ns:xmpp-tls'/>"); err != nil {
+		return err
+	}
+	reply, err := readUntil(conn, "/>")
+	if err != nil {
+		return fmt.Errorf("xmpp STARTTLS: %w", err)
+	}
+	if !bytes.Contains(reply, []byte("<proceed")) {
+		return fmt.Errorf("xmpp STARTTLS: %s", reply)
+	}
+	return nil
+}
+
+// readUntil reads from r byte by byte until marker has been seen, so no
+// bytes belonging to the TLS handshake are consumed.
+func readUntil(r io.Reader, marker string) ([]byte, error) {
+	var buf []byte
+	b := make([]byte, 1)
+	for !bytes.HasSuffix(buf, []byte(marker)) {
+		if _, err := io.ReadFull(r, b); err != nil {
+			return buf, err
+		}
+		buf = append(buf, b[0])
+		if len(buf) > 64*1024 {
+			return buf, fmt.Errorf("response too large")
+		}
+	}
+	return buf, nil
+}
+
+// postgresSSLRequest is the magic request code asking the server for TLS
+const postgresSSLRequest = 80877103
+
+func startTLSPostgres(conn net.Conn, host string) error {
+	req := make([]byte, 8)
+	binary.BigEndian.PutUint32(req[0:4], 8)
+	binary.BigEndian.PutUint32(req[4:8], postgresSSLRequest)
+	if _, err := conn.Write(req); err != nil {
+		return err
+	}
+	b := make([]byte, 1)
+	if _, err := io.ReadFull(conn, b); err != nil {
+		return fmt.Errorf("postgres SSLRequest: %w", err)
+	}
+	if b[0] != 'S' {
+		return fmt.Errorf("postgres server refused SSL (%q)", b[0])
+	}
+	return nil
+}
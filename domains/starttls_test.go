@@ -0,0 +1,264 @@
+package domains
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"testing"
+	"time"
+)
+
+// fakeUpgrade plays the server side of a protocol until the TLS handshake
+type fakeUpgrade func(conn net.Conn, r *bufio.Reader) error
+
+// expectLine reads a CRLF terminated line and checks its prefix
+func expectLine(r *bufio.Reader, prefix string) error {
+	line, err := r.ReadString('\n')
+	if err != nil {
+		return err
+	}
+	if !strings.HasPrefix(line, prefix) {
+		return fmt.Errorf("got %q, want %q", strings.TrimSpace(line), prefix)
+	}
+	return nil
+}
+
+func fakeSMTP(advertise bool) fakeUpgrade {
+	return func(conn net.Conn, r *bufio.Reader) error {
+		io.WriteString(conn, "220 mail.example.test ESMTP\r\n")
+		if err := expectLine(r, "EHLO [127.0.0.1]\r\n"); err != nil {
+			return err
+		}
+		if !advertise {
+			io.WriteString(conn, "250-mail.example.test\r\n250 PIPELINING\r\n")
+			return nil
+		}
+		io.WriteString(conn, "250-mail.example.test\r\n250-PIPELINING\r\n250 STARTTLS\r\n")
+		if err := expectLine(r, "STARTTLS\r\n"); err != nil {
+			return err
+		}
+		_, err := io.WriteString(conn, "220 Ready to start TLS\r\n")
+		return err
+	}
+}
+
+func fakeIMAP(conn net.Conn, r *bufio.Reader) error {
+	io.WriteString(conn, "* OK IMAP4rev1 ready\r\n")
+	if err := expectLine(r, "a001 STARTTLS\r\n"); err != nil {
+		return err
+	}
+	// Untagged responses come before the tagged completion
+	_, err := io.WriteString(conn, "* CAPABILITY IMAP4rev1\r\na001 OK Begin TLS negotiation\r\n")
+	return err
+}
+
+func fakePOP3(conn net.Conn, r *bufio.Reader) error {
+	io.WriteString(conn, "+OK POP3 ready\r\n")
+	if err := expectLine(r, "STLS\r\n"); err != nil {
+		return err
+	}
+	_, err := io.WriteString(conn, "+OK Begin TLS negotiation\r\n")
+	return err
+}
+
+func fakeFTP(conn net.Conn, r *bufio.Reader) error {
+	io.WriteString(conn, "220 FTP ready\r\n")
+	if err := expectLine(r, "AUTH TLS\r\n"); err != nil {
+		return err
+	}
+	_, err := io.WriteString(conn, "234 AUTH TLS successful\r\n")
+	return err
+}
+
+func fakeXMPP(conn net.Conn, r *bufio.Reader) error {
+	header, err := readUntil(r, "version='1.0'>")
+	if err != nil {
+		return err
+	}
+	if !bytes.Contains(header, []byte("to='mail.example.test'")) {
+		return fmt.Errorf("unexpected stream header %s", header)
+	}
+	io.WriteString(conn, "<?xml version='1.0'?><stream:stream from='mail.example.test' id='1' version='1.0' "+
+		"xmlns='jabber:client' xmlns:stream='http://etherx.jabber.org/streams'>"+
+		"<stream:features><starttls xmlns='urn:ietf:params:xml:ns:xmpp-tls'><required/></starttls></stream:features>")
+	request, err := readUntil(r, "/>")
+	if err != nil {
+		return err
+	}
+	if !bytes.Contains(request, []byte("<starttls")) {
+		return fmt.Errorf("unexpected request %s", request)
+	}
+	_, err = io.WriteString(conn, "<proceed xmlns='urn:ietf:params:xml:ns:xmpp-tls'/>")
+	return err
+}
+
+func fakeLDAP(conn net.Conn, r *bufio.Reader) error {
+	request, err := readBER(r)
+	if err != nil {
+		return err
+	}
+	if !bytes.Contains(request, []byte(ldapStartTLSOID)) {
+		return fmt.Errorf("unexpected request %x", request)
+	}
+	// LDAPMessage { messageID 1, ExtendedResponse { success, "", "" } }
+	_, err = conn.Write([]byte{0x30, 0x0c, 0x02, 0x01, 0x01, 0x78, 0x07, 0x0a, 0x01, 0x00, 0x04, 0x00, 0x04, 0x00})
+	return err
+}
+
+func fakePostgres(conn net.Conn, r *bufio.Reader) error {
+	request := make([]byte, 8)
+	if _, err := io.ReadFull(r, request); err != nil {
+		return err
+	}
+	if binary.BigEndian.Uint32(request[4:]) != postgresSSLRequest {
+		return fmt.Errorf("unexpected request %x", request)
+	}
+	_, err := conn.Write([]byte{'S'})
+	return err
+}
+
+// serveTLS accepts one connection, runs upgrade and completes the TLS
+// handshake with cert. The outcome is sent on the returned channel.
+func serveTLS(t *testing.T, upgrade fakeUpgrade, cert tls.Certificate) (string, <-chan error) {
+	t.Helper()
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { l.Close() })
+	errc := make(chan error, 1)
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			errc <- err
+			return
+		}
+		defer conn.Close()
+		conn.SetDeadline(time.Now().Add(5 * time.Second))
+		r := bufio.NewReader(conn)
+		if upgrade != nil {
+			if err := upgrade(conn, r); err != nil {
+				errc <- err
+				return
+			}
+		}
+		tlsConn := tls.Server(&bufferedConn{Conn: conn, reader: r}, &tls.Config{Certificates: []tls.Certificate{cert}})
+		errc <- tlsConn.Handshake()
+	}()
+	return l.Addr().String(), errc
+}
+
+// probe runs TestDomain for target, without the proxy of the environment
+// unless opts has one
+func probe(t *testing.T, target string, opts Options) Response {
+	t.Helper()
+	if opts.Timeout == 0 {
+		opts.Timeout = 5 * time.Second
+	}
+	if opts.Proxy == "" {
+		opts.Proxy = ProxyDirect
+	}
+	out := make(chan Response, 1)
+	TestDomain(context.Background(), target, "test", opts, out)
+	return <-out
+}
+
+func TestStartTLS(t *testing.T) {
+	ca := newTestCA(t, "Test CA")
+	leaf := newTestLeaf(t, ca, "mail.example.test")
+
+	tests := []struct {
+		scheme  string
+		upgrade fakeUpgrade
+	}{
+		{"smtps", nil},
+		{"smtp", fakeSMTP(true)},
+		{"submission", fakeSMTP(true)},
+		{"imap", fakeIMAP},
+		{"pop3", fakePOP3},
+		{"ftp", fakeFTP},
+		{"xmpp", fakeXMPP},
+		{"ldap", fakeLDAP},
+		{"postgres", fakePostgres},
+		{"postgresql", fakePostgres},
+	}
+	for _, tt := range tests {
+		t.Run(tt.scheme, func(t *testing.T) {
+			addr, errc := serveTLS(t, tt.upgrade, leaf.tlsCertificate(ca))
+			resp := probe(t, fmt.Sprintf("%s://mail.example.test?connect=%s", tt.scheme, addr), Options{TrustStore: testTrustStore(ca)})
+			if err := <-errc; err != nil {
+				t.Fatalf("server: %v", err)
+			}
+			if resp.Error != nil {
+				t.Fatalf("probe failed: %v", resp.Error)
+			}
+			if resp.Protocol != tt.scheme || resp.TLSVersion == 0 {
+				t.Errorf("got protocol %s version %x", resp.Protocol, resp.TLSVersion)
+			}
+			if len(resp.Chain) != 2 || !resp.Chain[0].Equal(leaf.cert) {
+				t.Errorf("leaf not captured, chain of %d", len(resp.Chain))
+			}
+			if !resp.Verified {
+				t.Errorf("chain not verified: %v", resp.VerifyError)
+			}
+		})
+	}
+}
+
+func TestStartTLSNotAdvertised(t *testing.T) {
+	ca := newTestCA(t, "Test CA")
+	leaf := newTestLeaf(t, ca, "mail.example.test")
+	addr, errc := serveTLS(t, fakeSMTP(false), leaf.tlsCertificate(ca))
+
+	resp := probe(t, "smtp://mail.example.test?connect="+addr, Options{})
+	<-errc
+	if resp.ErrorCode != ErrCodeStartTLS {
+		t.Errorf("got error code %q (%v), want %q", resp.ErrorCode, resp.Error, ErrCodeStartTLS)
+	}
+}
+
+func TestStartTLSXMPPEscapesHost(t *testing.T) {
+	client, server := net.Pipe()
+	defer client.Close()
+	go startTLSXMPP(client, "a'b<c&d")
+
+	header, err := readUntil(server, "version='1.0'>")
+	server.Close()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !bytes.Contains(header, []byte("to='a&#39;b&lt;c&amp;d'")) {
+		t.Errorf("host not escaped in %s", header)
+	}
+}
+
+func TestEHLOName(t *testing.T) {
+	tests := []struct {
+		addr net.Addr
+		want string
+	}{
+		{&net.TCPAddr{IP: net.ParseIP("192.0.2.10"), Port: 40000}, "[192.0.2.10]"},
+		{&net.TCPAddr{IP: net.ParseIP("2001:db8::1"), Port: 40000}, "[IPv6:2001:db8::1]"},
+	}
+	for _, tt := range tests {
+		if got := ehloName(fakeAddrConn{local: tt.addr}); got != tt.want {
+			t.Errorf("ehloName(%v) = %s, want %s", tt.addr, got, tt.want)
+		}
+	}
+}
+
+// fakeAddrConn is a connection only reporting its local address
+type fakeAddrConn struct {
+	net.Conn
+	local net.Addr
+}
+
+func (c fakeAddrConn) LocalAddr() net.Addr {
+	return c.local
+}
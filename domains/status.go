@@ -0,0 +1,154 @@
+package domains
+
+import (
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Status is a Nagios style check result, its value is the process exit code
+type Status int
+
+const (
+	StatusOK Status = iota
+	StatusWarning
+	StatusCritical
+	StatusUnknown
+)
+
+func (s Status) String() string {
+	switch s {
+	case StatusOK:
+		return "OK"
+	case StatusWarning:
+		return "WARNING"
+	case StatusCritical:
+		return "CRITICAL"
+	default:
+		return "UNKNOWN"
+	}
+}
+
+// worse orders statuses by severity, unknown sits between warning and critical
+func (s Status) worse(o Status) bool {
+	rank := map[Status]int{StatusOK: 0, StatusWarning: 1, StatusUnknown: 2, StatusCritical: 3}
+	return rank[s] > rank[o]
+}
+
+const (
+	DefaultWarn = 30 * 24 * time.Hour
+	DefaultCrit = 7 * 24 * time.Hour
+)
+
+// Thresholds are the remaining validity under which a certificate is
+// reported as warning or critical
+type Thresholds struct {
+	Warn time.Duration
+	Crit time.Duration
+}
+
+// DefaultThresholds returns the thresholds used when none are configured
+func DefaultThresholds() Thresholds {
+	return Thresholds{Warn: DefaultWarn, Crit: DefaultCrit}
+}
+
+// ParseDuration extends time.ParseDuration with day (d) and week (w) units,
+// a bare number is read as days
+func ParseDuration(s string) (time.Duration, error) {
+	s = strings.TrimSpace(s)
+	if n, err := strconv.Atoi(s); err == nil {
+		return time.Duration(n) * 24 * time.Hour, nil
+	}
+	for unit, d := range map[string]time.Duration{"d": 24 * time.Hour, "w": 7 * 24 * time.Hour} {
+		if strings.HasSuffix(s, unit) {
+			n, err := strconv.ParseFloat(strings.TrimSuffix(s, unit), 64)
+			// ParseFloat also reads NaN, Inf and values overflowing a Duration
+			if err != nil || math.IsNaN(n) || math.Abs(n*float64(d)) > math.MaxInt64 {
+				return 0, fmt.Errorf("invalid duration %q", s)
+			}
+			return time.Duration(n * float64(d)), nil
+		}
+	}
+	return time.ParseDuration(s)
+}
+
+// Status evaluates a single response against the thresholds, a hostname
+// probed on all its addresses gets the status of its worst backend
+func (t Thresholds) Status(r Response, now time.Time) Status {
+	if len(r.Backends) > 0 {
+		worst := StatusOK
+		for _, b := range r.Backends {
+			if s := t.Status(b, now); s.worse(worst) {
+				worst = s
+			}
+		}
+		return worst
+	}
+	if r.Revoked() {
+		return StatusCritical
+	}
+	if r.Error != nil {
+		// A broken certificate is a finding, failing to reach it is not
+		if r.ErrorCode.Certificate() {
+			return StatusCritical
+		}
+		return StatusUnknown
+	}
+	left := r.Expiry().Sub(now)
+	switch {
+	case left < t.Crit:
+		return StatusCritical
+	case left < t.Warn:
+		return StatusWarning
+	default:
+		return StatusOK
+	}
+}
+
+// Summary is the outcome of a whole run
+type Summary struct {
+	Status Status
+	Counts map[Status]int
+	// Worst is the response that drove the final status
+	Worst *Response
+}
+
+// Evaluate computes the run status, thresholds are looked up per environment
+// and fall back to def
+func Evaluate(resps []Response, thresholds map[string]Thresholds, def Thresholds) Summary {
+	now := time.Now()
+	sum := Summary{Status: StatusOK, Counts: map[Status]int{}}
+	if len(resps) == 0 {
+		sum.Status = StatusUnknown
+		return sum
+	}
+	for k, r := range resps {
+		t, ok := thresholds[r.Environment]
+		if !ok {
+			t = def
+		}
+		s := t.Status(r, now)
+		sum.Counts[s]++
+		if s.worse(sum.Status) || (s == sum.Status && s != StatusOK && r.Expiry().Before(sum.Worst.Expiry())) {
+			sum.Status = s
+			sum.Worst = &resps[k]
+		}
+	}
+	return sum
+}
+
+func (s Summary) String() string {
+	var str strings.Builder
+	str.WriteString(fmt.Sprintf("%s: %d critical, %d warning, %d unknown, %d ok",
+		s.Status, s.Counts[StatusCritical], s.Counts[StatusWarning], s.Counts[StatusUnknown], s.Counts[StatusOK]))
+	if s.Worst != nil {
+		if s.Worst.Error != nil {
+			str.WriteString(fmt.Sprintf(" (worst: %s %s)", s.Worst.Endpoint(), s.Worst.KnownError()))
+		} else {
+			str.WriteString(fmt.Sprintf(" (worst: %s expires %s)", s.Worst.Endpoint(), s.Worst.Expiry().Format("2006-01-02")))
+		}
+	}
+	return str.String()
+}
@@ -0,0 +1,136 @@
+package domains
+
+import (
+	"errors"
+	"math/big"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseDuration(t *testing.T) {
+	tests := []struct {
+		in   string
+		want time.Duration
+	}{
+		{"30", 30 * 24 * time.Hour},
+		{" 7 ", 7 * 24 * time.Hour},
+		{"14d", 14 * 24 * time.Hour},
+		{"1.5d", 36 * time.Hour},
+		{"2w", 14 * 24 * time.Hour},
+		{"0.5w", 84 * time.Hour},
+		{"72h", 72 * time.Hour},
+		{"90m", 90 * time.Minute},
+	}
+	for _, tt := range tests {
+		if got, err := ParseDuration(tt.in); err != nil || got != tt.want {
+			t.Errorf("ParseDuration(%q) = %v, %v; want %v", tt.in, got, err, tt.want)
+		}
+	}
+	for _, in := range []string{"", "d", "w", "xd", "1y", "1d12h", "NaNd", "Infw", "1e300d", "ten"} {
+		if got, err := ParseDuration(in); err == nil {
+			t.Errorf("ParseDuration(%q) = %v, want an error", in, got)
+		}
+	}
+}
+
+func TestThresholdsStatus(t *testing.T) {
+	now := time.Now()
+	th := Thresholds{Warn: 30 * 24 * time.Hour, Crit: 7 * 24 * time.Hour}
+	expiring := func(left time.Duration) Response {
+		return Response{Domain: "example.test", NotAfter: now.Add(left)}
+	}
+	tests := []struct {
+		name string
+		resp Response
+		want Status
+	}{
+		{"far", expiring(90 * 24 * time.Hour), StatusOK},
+		{"at warning", expiring(th.Warn), StatusOK},
+		{"under warning", expiring(th.Warn - time.Second), StatusWarning},
+		{"at critical", expiring(th.Crit), StatusWarning},
+		{"under critical", expiring(th.Crit - time.Second), StatusCritical},
+		{"expired", expiring(-time.Hour), StatusCritical},
+		{"unreachable", Response{Error: errors.New("refused"), ErrorCode: ErrCodeRefused}, StatusUnknown},
+		{"certificate error", Response{Error: errors.New("expired"), ErrorCode: ErrCodeExpired}, StatusCritical},
+		{"revoked", Response{NotAfter: now.Add(90 * 24 * time.Hour), OCSPResponder: &OCSPResult{Status: RevocationRevoked}}, StatusCritical},
+		{"worst backend", Response{Backends: []Response{
+			expiring(90 * 24 * time.Hour),
+			expiring(10 * 24 * time.Hour),
+			{Error: errors.New("refused"), ErrorCode: ErrCodeRefused},
+		}}, StatusUnknown},
+	}
+	for _, tt := range tests {
+		if got := th.Status(tt.resp, now); got != tt.want {
+			t.Errorf("%s: got %s, want %s", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestEvaluate(t *testing.T) {
+	now := time.Now()
+	expiring := func(env, domain string, left time.Duration) Response {
+		return Response{Environment: env, Domain: domain, Port: "443", NotAfter: now.Add(left), SerialNumber: big.NewInt(1)}
+	}
+	unreachable := Response{Environment: "prod", Domain: "down.example.test", Port: "443", Error: errors.New("refused"), ErrorCode: ErrCodeRefused}
+	def := DefaultThresholds()
+
+	tests := []struct {
+		name       string
+		resps      []Response
+		thresholds map[string]Thresholds
+		want       Status
+		worst      string
+	}{
+		{"empty", nil, nil, StatusUnknown, ""},
+		{"ok", []Response{expiring("prod", "a.example.test", 90*24*time.Hour)}, nil, StatusOK, ""},
+		// Unknown ranks above warning and below critical
+		{"unknown over warning", []Response{
+			expiring("prod", "a.example.test", 20*24*time.Hour),
+			unreachable,
+		}, nil, StatusUnknown, "down.example.test"},
+		{"critical over unknown", []Response{
+			unreachable,
+			expiring("prod", "a.example.test", 2*24*time.Hour),
+		}, nil, StatusCritical, "a.example.test"},
+		// The soonest expiry drives an equal status
+		{"soonest warning", []Response{
+			expiring("prod", "a.example.test", 20*24*time.Hour),
+			expiring("prod", "b.example.test", 10*24*time.Hour),
+			expiring("prod", "c.example.test", 15*24*time.Hour),
+		}, nil, StatusWarning, "b.example.test"},
+		{"environment thresholds", []Response{
+			expiring("prod", "a.example.test", 20*24*time.Hour),
+			expiring("dev", "b.example.test", 20*24*time.Hour),
+		}, map[string]Thresholds{"dev": {Warn: 10 * 24 * time.Hour, Crit: 5 * 24 * time.Hour}}, StatusWarning, "a.example.test"},
+		{"environment critical", []Response{
+			expiring("dev", "b.example.test", 20*24*time.Hour),
+		}, map[string]Thresholds{"dev": {Warn: 60 * 24 * time.Hour, Crit: 30 * 24 * time.Hour}}, StatusCritical, "b.example.test"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			sum := Evaluate(tt.resps, tt.thresholds, def)
+			if sum.Status != tt.want {
+				t.Errorf("got %s, want %s (%s)", sum.Status, tt.want, sum)
+			}
+			if worst := ""; sum.Worst != nil {
+				if worst = sum.Worst.Domain; worst != tt.worst {
+					t.Errorf("got worst %s, want %s", worst, tt.worst)
+				}
+			} else if tt.worst != "" {
+				t.Errorf("no worst response, want %s", tt.worst)
+			}
+			if n := sum.Counts[StatusOK] + sum.Counts[StatusWarning] + sum.Counts[StatusCritical] + sum.Counts[StatusUnknown]; n != len(tt.resps) {
+				t.Errorf("got %d counted, want %d", n, len(tt.resps))
+			}
+		})
+	}
+
+	sum := Evaluate([]Response{unreachable, expiring("prod", "a.example.test", 20*24*time.Hour)}, nil, def)
+	if got := sum.String(); !strings.HasPrefix(got, "UNKNOWN: 0 critical, 1 warning, 1 unknown, 0 ok (worst: down.example.test:443 ") {
+		t.Errorf("got summary %q", got)
+	}
+	if int(StatusOK) != 0 || int(StatusWarning) != 1 || int(StatusCritical) != 2 || int(StatusUnknown) != 3 {
+		t.Error("statuses no longer match the Nagios exit codes")
+	}
+}
@@ -0,0 +1,204 @@
+package domains
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+const (
+	DefaultProtocol = "https"
+	DefaultPort     = "443"
+)
+
+// Target is an endpoint to probe, parsed from a query entry
+type Target struct {
+	Protocol string
+	Host     string
+	Port     string
+	// Connect overrides the host:port dialed, Host is still the name checked
+	Connect string
+	// SNI overrides the server name sent in the handshake, NoSNI sends none
+	SNI   string
+	NoSNI bool
+	// Verify overrides the name the certificate is verified against
+	Verify string
+}
+
+// Address returns the host:port form of the target, bracketing IPv6 literals
+func (t Target) Address() string {
+	return net.JoinHostPort(t.Host, t.Port)
+}
+
+// DialAddress returns the host:port the probe connects to
+func (t Target) DialAddress() string {
+	if t.Connect != "" {
+		return t.Connect
+	}
+	return t.Address()
+}
+
+// ServerName returns the SNI sent in the handshake, empty when none is sent
+func (t Target) ServerName() string {
+	switch {
+	case t.NoSNI:
+		return ""
+	case t.SNI != "":
+		return t.SNI
+	}
+	return t.Host
+}
+
+// VerifyName returns the name the certificate is verified against
+func (t Target) VerifyName() string {
+	switch {
+	case t.Verify != "":
+		return t.Verify
+	case t.SNI != "":
+		return t.SNI
+	}
+	return t.Host
+}
+
+// withDefaults fills the connect address, SNI and verification name from
+// opts when the target does not set them
+func (t Target) withDefaults(opts Options) (Target, error) {
+	if t.Connect == "" && opts.Connect != "" {
+		addr, err := connectAddress(opts.Connect, t.Port)
+		if err != nil {
+			return Target{}, fmt.Errorf("%w: connect address %q: %v", ErrInvalidTarget, opts.Connect, err)
+		}
+		t.Connect = addr
+	}
+	if t.SNI == "" && !t.NoSNI {
+		t.SNI, t.NoSNI = opts.SNI, opts.NoSNI
+	}
+	if t.Verify == "" {
+		t.Verify = opts.VerifyName
+	}
+	return t, nil
+}
+
+// ParseTarget accepts the forms found in query files and lists:
+// host, host:port, [ipv6]:port, bare IPv6 literals and scheme://host:port/path URLs
+// where scheme is https or one of the STARTTLS protocols (smtp, imap, postgres...).
+// Any form can end with ?connect=addr&sni=name&verify=name to dial another
+// address, send another server name (none with an empty sni=) or verify the
+// certificate against another name. Other query parameters are ignored on
+// URLs and rejected on the other forms.
+func ParseTarget(raw string) (Target, error) {
+	s := strings.TrimSpace(raw)
+	if s == "" {
+		return Target{}, fmt.Errorf("%w: empty", ErrInvalidTarget)
+	}
+
+	if strings.Contains(s, "://") {
+		u, err := url.Parse(s)
+		if err != nil {
+			return Target{}, fmt.Errorf("%w %q: %v", ErrInvalidTarget, raw, err)
+		}
+		scheme := strings.ToLower(u.Scheme)
+		proto, ok := protocols[scheme]
+		if !ok {
+			return Target{}, fmt.Errorf("%w %q: unsupported scheme %q", ErrInvalidTarget, raw, u.Scheme)
+		}
+		t := Target{Protocol: scheme, Host: u.Hostname(), Port: u.Port()}
+		if t.Port == "" {
+			t.Port = proto.port
+		}
+		return t.withParams(raw, u.RawQuery, false)
+	}
+
+	var params string
+	if k := strings.Index(s, "?"); k >= 0 {
+		s, params = s[:k], s[k+1:]
+	}
+	t, err := parseHostPort(raw, s, DefaultPort)
+	if err != nil {
+		return Target{}, err
+	}
+	return t.withParams(raw, params, true)
+}
+
+// parseHostPort parses the URL-less target forms, port is used when none is given
+func parseHostPort(raw, s, port string) (Target, error) {
+	// Bare IPv6 literal, possibly bracketed, without a port
+	if strings.Count(s, ":") > 1 && !strings.Contains(s, "]:") {
+		host := strings.TrimSuffix(strings.TrimPrefix(s, "["), "]")
+		if ip := net.ParseIP(strings.SplitN(host, "%", 2)[0]); ip == nil {
+			return Target{}, fmt.Errorf("%w %q: not an IPv6 address", ErrInvalidTarget, raw)
+		}
+		return Target{Protocol: DefaultProtocol, Host: host, Port: port}.validate(raw)
+	}
+
+	if !strings.Contains(s, ":") {
+		return Target{Protocol: DefaultProtocol, Host: s, Port: port}.validate(raw)
+	}
+
+	host, port, err := net.SplitHostPort(s)
+	if err != nil {
+		return Target{}, fmt.Errorf("%w %q: %v", ErrInvalidTarget, raw, err)
+	}
+	return Target{Protocol: DefaultProtocol, Host: host, Port: port}.validate(raw)
+}
+
+// withParams applies the connect, sni and verify parameters of a target,
+// rejecting any other one when strict
+func (t Target) withParams(raw, query string, strict bool) (Target, error) {
+	t, err := t.validate(raw)
+	if err != nil || query == "" {
+		return t, err
+	}
+	params, err := url.ParseQuery(query)
+	if err != nil {
+		return Target{}, fmt.Errorf("%w %q: %v", ErrInvalidTarget, raw, err)
+	}
+	for key := range params {
+		switch key {
+		case "connect", "sni", "verify":
+		default:
+			if strict {
+				return Target{}, fmt.Errorf("%w %q: unknown parameter %q", ErrInvalidTarget, raw, key)
+			}
+		}
+	}
+	if params.Has("connect") {
+		if t.Connect, err = connectAddress(params.Get("connect"), t.Port); err != nil {
+			return Target{}, fmt.Errorf("%w %q: connect address: %v", ErrInvalidTarget, raw, err)
+		}
+	}
+	if params.Has("sni") {
+		t.SNI = params.Get("sni")
+		t.NoSNI = t.SNI == ""
+	}
+	t.Verify = params.Get("verify")
+	return t, nil
+}
+
+// connectAddress normalizes a host, host:port or IPv6 address to host:port,
+// using port when none is given
+func connectAddress(s, port string) (string, error) {
+	t, err := parseHostPort(s, s, port)
+	if err != nil {
+		return "", err
+	}
+	return t.Address(), nil
+}
+
+func (t Target) validate(raw string) (Target, error) {
+	if t.Host == "" {
+		return Target{}, fmt.Errorf("%w %q: missing host", ErrInvalidTarget, raw)
+	}
+	// A path without a scheme would otherwise fail later as a DNS error
+	if strings.ContainsAny(t.Host, "/?") || strings.IndexFunc(t.Host, unicode.IsSpace) >= 0 {
+		return Target{}, fmt.Errorf("%w %q: bad host %q, URLs need a scheme", ErrInvalidTarget, raw, t.Host)
+	}
+	p, err := strconv.Atoi(t.Port)
+	if err != nil || p < 1 || p > 65535 {
+		return Target{}, fmt.Errorf("%w %q: bad port %q", ErrInvalidTarget, raw, t.Port)
+	}
+	return t, nil
+}
@@ -0,0 +1,91 @@
+package domains
+
+import (
+	"errors"
+	"testing"
+)
+
+func TestParseTargetParams(t *testing.T) {
+	tests := []struct {
+		raw  string
+		want Target
+	}{
+		{"example.com?connect=192.0.2.1&sni=www.example.com&verify=example.net", Target{
+			Protocol: DefaultProtocol, Host: "example.com", Port: "443",
+			Connect: "192.0.2.1:443", SNI: "www.example.com", Verify: "example.net",
+		}},
+		{"example.com:8443?connect=[2001:db8::1]", Target{Protocol: DefaultProtocol, Host: "example.com", Port: "8443", Connect: "[2001:db8::1]:8443"}},
+		{"example.com?sni=", Target{Protocol: DefaultProtocol, Host: "example.com", Port: "443", NoSNI: true}},
+		// URLs keep their own query parameters, only the known keys are used
+		{"https://example.com/path?x=1", Target{Protocol: DefaultProtocol, Host: "example.com", Port: "443"}},
+		{"https://example.com/path?x=1&sni=a.example.com", Target{Protocol: DefaultProtocol, Host: "example.com", Port: "443", SNI: "a.example.com"}},
+	}
+	for _, tt := range tests {
+		got, err := ParseTarget(tt.raw)
+		if err != nil || got != tt.want {
+			t.Errorf("ParseTarget(%q) = %+v, %v; want %+v", tt.raw, got, err, tt.want)
+		}
+	}
+
+	for _, raw := range []string{"example.com?x=1", "example.com?connect=example.net:0", "example.com?connect="} {
+		if _, err := ParseTarget(raw); !errors.Is(err, ErrInvalidTarget) {
+			t.Errorf("ParseTarget(%q): got %v, want %v", raw, err, ErrInvalidTarget)
+		}
+	}
+}
+
+func TestParseTarget(t *testing.T) {
+	https := func(host, port string) Target {
+		return Target{Protocol: DefaultProtocol, Host: host, Port: port}
+	}
+	tests := []struct {
+		raw  string
+		want Target
+	}{
+		{"example.com", https("example.com", "443")},
+		{"  example.com  ", https("example.com", "443")},
+		{"example.com:8443", https("example.com", "8443")},
+		{"192.0.2.1:443", https("192.0.2.1", "443")},
+		{"[2001:db8::1]:8443", https("2001:db8::1", "8443")},
+		{"2001:db8::1", https("2001:db8::1", "443")},
+		{"[2001:db8::1]", https("2001:db8::1", "443")},
+		{"fe80::1%eth0", https("fe80::1%eth0", "443")},
+		{"https://example.com", https("example.com", "443")},
+		{"https://example.com:8443/path", https("example.com", "8443")},
+		{"HTTPS://example.com/", https("example.com", "443")},
+		{"https://[2001:db8::1]:8443/", https("2001:db8::1", "8443")},
+		{"https://example.com/path?x=1", https("example.com", "443")},
+		{"smtp://mail.example.com", Target{Protocol: "smtp", Host: "mail.example.com", Port: "25"}},
+		{"smtps://mail.example.com", Target{Protocol: "smtps", Host: "mail.example.com", Port: "465"}},
+		{"submission://mail.example.com", Target{Protocol: "submission", Host: "mail.example.com", Port: "587"}},
+		{"imap://mail.example.com", Target{Protocol: "imap", Host: "mail.example.com", Port: "143"}},
+		{"ldap://ldap.example.com:10389", Target{Protocol: "ldap", Host: "ldap.example.com", Port: "10389"}},
+		{"postgres://db.example.com", Target{Protocol: "postgres", Host: "db.example.com", Port: "5432"}},
+		{"xmpp://chat.example.com", Target{Protocol: "xmpp", Host: "chat.example.com", Port: "5222"}},
+	}
+	for _, tt := range tests {
+		got, err := ParseTarget(tt.raw)
+		if err != nil || got != tt.want {
+			t.Errorf("ParseTarget(%q) = %+v, %v; want %+v", tt.raw, got, err, tt.want)
+		}
+	}
+
+	for _, raw := range []string{
+		"",
+		":443",
+		"example.com:0",
+		"example.com:https",
+		"example.com:70000",
+		"2001:db8::zz",
+		"example.com/path",
+		"example.com/path?x=1",
+		"exa mple.com",
+		"gopher://example.com",
+		"https://",
+		"https://example.com:99999/",
+	} {
+		if got, err := ParseTarget(raw); !errors.Is(err, ErrInvalidTarget) {
+			t.Errorf("ParseTarget(%q) = %+v, %v; want %v", raw, got, err, ErrInvalidTarget)
+		}
+	}
+}
@@ -0,0 +1,134 @@
+package domains
+
+import (
+	"bytes"
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// SystemTrustStore names the system roots in responses
+const SystemTrustStore = "system"
+
+// TrustStore is the set of roots certificates are verified against
+type TrustStore struct {
+	// Name lists the CA file and directory the custom roots were read from
+	Name string
+	// Pool holds the custom roots, with the system ones when Appended
+	Pool     *x509.CertPool
+	Appended bool
+	// certs are the custom roots, used to tell which store validated a chain
+	certs []*x509.Certificate
+}
+
+// LoadTrustStore reads the PEM certificates of caFile and of every file in
+// caDir. With appendSystem they are added to the system roots instead of
+// replacing them.
+func LoadTrustStore(caFile, caDir string, appendSystem bool) (*TrustStore, error) {
+	var (
+		files []string
+		names []string
+	)
+	if caFile != "" {
+		caFile = os.ExpandEnv(caFile)
+		files = append(files, caFile)
+		names = append(names, caFile)
+	}
+	if caDir != "" {
+		caDir = os.ExpandEnv(caDir)
+		entries, err := os.ReadDir(caDir)
+		if err != nil {
+			return nil, fmt.Errorf("can't read CA directory: %w", err)
+		}
+		for _, e := range entries {
+			if !e.IsDir() {
+				files = append(files, filepath.Join(caDir, e.Name()))
+			}
+		}
+		names = append(names, caDir)
+	}
+
+	store := &TrustStore{Name: strings.Join(names, ", "), Pool: x509.NewCertPool(), Appended: appendSystem}
+	if appendSystem {
+		pool, err := x509.SystemCertPool()
+		if err != nil {
+			return nil, fmt.Errorf("can't load system roots: %w", err)
+		}
+		store.Pool = pool
+	}
+	for _, f := range files {
+		certs, err := readCertificates(f)
+		if err != nil {
+			return nil, err
+		}
+		for _, c := range certs {
+			store.Pool.AddCert(c)
+		}
+		store.certs = append(store.certs, certs...)
+	}
+	if len(store.certs) == 0 {
+		return nil, fmt.Errorf("no certificate found in %s", strings.Join(names, ", "))
+	}
+	return store, nil
+}
+
+// readCertificates parses every PEM certificate of a file
+func readCertificates(name string) ([]*x509.Certificate, error) {
+	data, err := os.ReadFile(name)
+	if err != nil {
+		return nil, fmt.Errorf("can't read CA file: %w", err)
+	}
+	var certs []*x509.Certificate
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			break
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		c, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("invalid certificate in %s: %w", name, err)
+		}
+		certs = append(certs, c)
+	}
+	return certs, nil
+}
+
+// String describes the roots of the store
+func (t *TrustStore) String() string {
+	if t == nil {
+		return SystemTrustStore
+	}
+	if t.Appended {
+		return SystemTrustStore + " + " + t.Name
+	}
+	return t.Name
+}
+
+// roots returns the pool to verify against, nil meaning the system roots
+func (t *TrustStore) roots() *x509.CertPool {
+	if t == nil {
+		return nil
+	}
+	return t.Pool
+}
+
+// validated names the store holding the root of a verified chain
+func (t *TrustStore) validated(chain []*x509.Certificate) string {
+	if t == nil || len(chain) == 0 {
+		return SystemTrustStore
+	}
+	root := chain[len(chain)-1]
+	for _, c := range t.certs {
+		if bytes.Equal(c.Raw, root.Raw) {
+			return t.Name
+		}
+	}
+	return SystemTrustStore
+}
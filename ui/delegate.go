@@ -95,17 +95,37 @@ func Details(i domains.Response) string {
 		green  = lipgloss.NewStyle().Foreground(lipgloss.Color("10"))
 	)
 
-	if i.Error == nil {
+	if i.HasCert() {
 		now := time.Now()
 		inOneMonth := now.AddDate(0, 1, 0)
 		inFourMonth := now.AddDate(0, 4, 0)
+		expiry := i.Expiry()
 		var dateOutput string
-		if i.NotAfter.Before(inOneMonth) {
-			dateOutput = red.Render(i.NotAfter.Format("2006-01-02"))
-		} else if i.NotAfter.Before(inFourMonth) {
-			dateOutput = orange.Render(i.NotAfter.Format("2006-01-02"))
+		if expiry.Before(inOneMonth) {
+			dateOutput = red.Render(expiry.Format("2006-01-02"))
+		} else if expiry.Before(inFourMonth) {
+			dateOutput = orange.Render(expiry.Format("2006-01-02"))
 		} else {
-			dateOutput = green.Render(i.NotAfter.Format("2006-01-02"))
+			dateOutput = green.Render(expiry.Format("2006-01-02"))
+		}
+		if i.SerialMismatch() {
+			dateOutput += red.Render(fmt.Sprintf(" (%d backends, serials differ)", len(i.Backends)))
+		} else if len(i.Backends) > 1 {
+			dateOutput += fmt.Sprintf(" (%d backends)", len(i.Backends))
+		}
+		if i.Attempts > 1 {
+			// Flapping endpoint, it needed retries to answer
+			dateOutput += orange.Render(fmt.Sprintf(" (%d attempts)", i.Attempts))
+		}
+		if len(i.Deprecated()) > 0 {
+			dateOutput += red.Render(" (deprecated TLS)")
+		}
+		if findings := i.Findings; len(findings) > 0 {
+			dateOutput += orange.Render(fmt.Sprintf(" (%d findings)", len(findings)))
+		}
+		if i.Error != nil {
+			// Certificate captured but not valid
+			dateOutput += red.Render(fmt.Sprintf(" (%s)", i.KnownError()))
 		}
 		return fmt.Sprintf("%v | %v", i.Issuer.CommonName, dateOutput)
 	} else {
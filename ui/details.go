@@ -1,7 +1,9 @@
 package ui
 
 import (
+	"crypto/x509"
 	"fmt"
+	"net/http"
 	"strings"
 
 	"github.com/fabio42/ssl-checker/domains"
@@ -59,16 +61,71 @@ func (m domainDetails) helpView() string {
 
 func (m domainDetails) setData(i domains.Response) {
 	var details strings.Builder
-	details.WriteString(fmt.Sprintf("# %v\n", i.Domain))
+	details.WriteString(fmt.Sprintf("# %v\n", i.Endpoint()))
 	details.WriteString("\n")
+	if i.Connect != "" {
+		details.WriteString(fmt.Sprintf("- Connect to  : %s\n", i.Connect))
+	}
+	if i.Address != "" {
+		details.WriteString(fmt.Sprintf("- Address     : %s\n", i.Address))
+	}
+	if i.Proxy != "" {
+		details.WriteString(fmt.Sprintf("- Proxy       : %s\n", i.Proxy))
+	}
+	if i.ServerName != "" {
+		details.WriteString(fmt.Sprintf("- SNI         : %s\n", i.ServerName))
+	} else if i.Port != "" {
+		details.WriteString("- SNI         : none\n")
+	}
+	if i.VerifyName != "" {
+		details.WriteString(fmt.Sprintf("- Verified as : %s\n", i.VerifyName))
+	}
+	if i.TrustStore != "" {
+		details.WriteString(fmt.Sprintf("- Trust store : %s\n", i.TrustStore))
+	}
+	details.WriteString("\n")
+	if i.ClientCertRequested {
+		details.WriteString("## Client certificate requested")
+		details.WriteString("\n")
+		if len(i.AcceptableCAs) == 0 {
+			details.WriteString("- Acceptable CAs: any\n")
+		}
+		for _, ca := range i.AcceptableCAs {
+			details.WriteString(fmt.Sprintf("- %s\n", ca))
+		}
+		details.WriteString("\n")
+	}
+	if len(i.Backends) > 0 {
+		writeBackends(&details, i)
+	}
+	if i.Attempts > 1 {
+		details.WriteString(fmt.Sprintf("## Attempts: %d", i.Attempts))
+		details.WriteString("\n")
+		for k, err := range i.AttemptErrors {
+			details.WriteString(fmt.Sprintf("%d. %v\n", k+1, err))
+		}
+		details.WriteString("\n")
+	}
 	if i.Error != nil {
 		details.WriteString(fmt.Sprintf("- Error       : %v\n", i.Error))
-	} else {
+		details.WriteString(fmt.Sprintf("- Error code  : %s\n", i.ErrorCode))
+		details.WriteString("\n")
+	}
+	if i.HasCert() {
+		if i.Verified {
+			details.WriteString("- Verification: passed\n")
+		} else {
+			details.WriteString("- Verification: **failed**\n")
+		}
 		details.WriteString("## Issuer")
 		details.WriteString("\n")
-		details.WriteString(fmt.Sprintf("- Organization: %s\n", i.Issuer.Organization[0]))
+		if len(i.Issuer.Organization) > 0 {
+			details.WriteString(fmt.Sprintf("- Organization: %s\n", i.Issuer.Organization[0]))
+		}
 		details.WriteString(fmt.Sprintf("- Common Name : %s\n", i.Issuer.CommonName))
-		details.WriteString(fmt.Sprintf("- Country     : %s\n", i.Issuer.Country[0]))
+		if len(i.Issuer.Country) > 0 {
+			details.WriteString(fmt.Sprintf("- Country     : %s\n", i.Issuer.Country[0]))
+		}
 		details.WriteString("## Validity")
 		details.WriteString("\n")
 		details.WriteString(fmt.Sprintf("- Not before: %v\n", i.NotBefore))
@@ -93,6 +150,18 @@ func (m domainDetails) setData(i domains.Response) {
 		for _, v := range i.SAN {
 			details.WriteString(fmt.Sprintf("  - %v\n", v))
 		}
+		writeChain(&details, "Presented Chain", i.Chain, i.ExpiringCert())
+		if !i.ChainOrdered() {
+			details.WriteString("\n**Warning: presented chain is not in issuing order**\n")
+		}
+		writeChain(&details, "Verified Chain", i.VerifiedChain, nil)
+		writeFindings(&details, i.Findings)
+		writeRevocation(&details, i)
+		writeSCTs(&details, i)
+		writeCAA(&details, i)
+		writeDANE(&details, i)
+		writeHTTP(&details, i)
+		writeProtocol(&details, i)
 	}
 
 	str, err := m.renderer.Render(details.String())
@@ -102,6 +171,296 @@ func (m domainDetails) setData(i domains.Response) {
 	m.viewport.SetContent(str)
 }
 
+// writeRevocation renders the stapled and responder OCSP responses and the
+// CRL lookups
+func writeRevocation(details *strings.Builder, i domains.Response) {
+	details.WriteString("## Revocation")
+	details.WriteString("\n")
+	if i.OCSPStapled == nil {
+		details.WriteString("- Stapled OCSP: none\n")
+	}
+	for _, r := range []struct {
+		title string
+		ocsp  *domains.OCSPResult
+	}{{"Stapled OCSP", i.OCSPStapled}, {"OCSP responder", i.OCSPResponder}} {
+		if r.ocsp == nil {
+			continue
+		}
+		status := r.ocsp.Status
+		if status == domains.RevocationRevoked {
+			status = fmt.Sprintf("**revoked** at %s", r.ocsp.RevokedAt.Format("2006-01-02"))
+		}
+		if status != "" {
+			details.WriteString(fmt.Sprintf("- %s: %s\n", r.title, status))
+			details.WriteString(fmt.Sprintf("  - This update: %v\n", r.ocsp.ThisUpdate))
+			if !r.ocsp.NextUpdate.IsZero() {
+				details.WriteString(fmt.Sprintf("  - Next update: %v\n", r.ocsp.NextUpdate))
+			}
+		} else {
+			details.WriteString(fmt.Sprintf("- %s: unavailable\n", r.title))
+		}
+		if r.ocsp.Responder != "" {
+			details.WriteString(fmt.Sprintf("  - Responder  : %s\n", r.ocsp.Responder))
+		}
+		if r.ocsp.Error != nil {
+			details.WriteString(fmt.Sprintf("  - Error      : %v\n", r.ocsp.Error))
+		}
+	}
+	for _, r := range i.CRL {
+		status := r.Status
+		switch {
+		case status == domains.RevocationRevoked:
+			status = fmt.Sprintf("**revoked** at %s", r.RevokedAt.Format("2006-01-02"))
+		case status == "":
+			status = "unavailable"
+		}
+		details.WriteString(fmt.Sprintf("- CRL for %s: %s\n", r.Subject, status))
+		details.WriteString(fmt.Sprintf("  - URL        : %s\n", r.URL))
+		if r.Status != "" {
+			details.WriteString(fmt.Sprintf("  - Next update: %v\n", r.NextUpdate))
+		}
+		if r.Stale {
+			details.WriteString("  - **Stale CRL, past its next update**\n")
+		}
+		if r.Error != nil {
+			details.WriteString(fmt.Sprintf("  - Error      : %v\n", r.Error))
+		}
+	}
+}
+
+// writeSCTs renders the signed certificate timestamps and where they came from
+func writeSCTs(details *strings.Builder, i domains.Response) {
+	details.WriteString("## Certificate Transparency")
+	details.WriteString("\n")
+	if len(i.SCTs) == 0 {
+		details.WriteString("- SCTs: none\n")
+		return
+	}
+	if unverified := i.UnverifiedSCTs(); unverified > 0 {
+		details.WriteString(fmt.Sprintf("- SCTs   : %d (%d unverified, no CT log list)\n", len(i.SCTs), unverified))
+	} else {
+		details.WriteString(fmt.Sprintf("- SCTs   : %d (%d valid)\n", len(i.SCTs), i.ValidSCTs()))
+	}
+	details.WriteString(fmt.Sprintf("- Sources: %s\n", strings.Join(i.SCTSources(), ", ")))
+	for _, s := range i.SCTs {
+		log := s.Log
+		if log == "" {
+			log = s.LogID
+		}
+		details.WriteString(fmt.Sprintf("- %s from %s\n", s.Timestamp.Format("2006-01-02 15:04"), s.Source))
+		if log != "" {
+			details.WriteString(fmt.Sprintf("  - Log      : %s\n", log))
+		}
+		switch {
+		case s.Error != nil:
+			details.WriteString(fmt.Sprintf("  - **Error  : %v**\n", s.Error))
+		case s.Verified:
+			details.WriteString("  - Signature: valid\n")
+		default:
+			details.WriteString("  - Signature: not checked, no log list\n")
+		}
+	}
+}
+
+// writeCAA renders the CAA record set of the domain and whether it
+// authorizes the issuer
+func writeCAA(details *strings.Builder, i domains.Response) {
+	if i.CAA == nil {
+		return
+	}
+	details.WriteString("## CAA")
+	details.WriteString("\n")
+	switch {
+	case i.CAA.Error != nil:
+		details.WriteString(fmt.Sprintf("- Error: %v\n", i.CAA.Error))
+		return
+	case i.CAA.Domain == "":
+		details.WriteString("- No records, any CA may issue\n")
+		return
+	}
+	details.WriteString(fmt.Sprintf("- Found at  : %s\n", i.CAA.Domain))
+	for _, tag := range []struct {
+		name   string
+		values []string
+	}{{"issue", i.CAA.Issue}, {"issuewild", i.CAA.IssueWild}, {"iodef", i.CAA.Iodef}} {
+		if len(tag.values) > 0 {
+			details.WriteString(fmt.Sprintf("- %-10s: %s\n", tag.name, strings.Join(tag.values, ", ")))
+		}
+	}
+	switch i.CAAStatus() {
+	case domains.CAAStatusAuthorized:
+		details.WriteString("- Issuer    : authorized\n")
+	case domains.CAAStatusUnauthorized:
+		details.WriteString("- Issuer    : **not authorized**\n")
+	default:
+		details.WriteString("- Issuer    : unknown, no known CAA identifier for this CA\n")
+	}
+}
+
+// writeDANE renders the TLSA records of the endpoint and whether each one
+// matches the chain
+func writeDANE(details *strings.Builder, i domains.Response) {
+	if i.DANE == nil {
+		return
+	}
+	details.WriteString("## DANE/TLSA")
+	details.WriteString("\n")
+	details.WriteString(fmt.Sprintf("- Name: %s\n", i.DANE.Name))
+	if i.DANE.Error != nil {
+		details.WriteString(fmt.Sprintf("- Error: %v\n", i.DANE.Error))
+		return
+	}
+	if len(i.DANE.Records) == 0 {
+		details.WriteString("- No records\n")
+	}
+	for _, r := range i.DANE.Records {
+		status := "matched"
+		if !r.Matched {
+			status = "**unmatched**"
+		}
+		details.WriteString(fmt.Sprintf("- %d %d %d: %s\n", r.Usage, r.Selector, r.MatchingType, status))
+		details.WriteString(fmt.Sprintf("  - Data : %s\n", r.Data))
+		if r.Error != nil {
+			details.WriteString(fmt.Sprintf("  - Error: %v\n", r.Error))
+		}
+	}
+}
+
+// writeHTTP renders the answer to the request sent over the TLS connection
+func writeHTTP(details *strings.Builder, i domains.Response) {
+	if i.HTTP == nil {
+		return
+	}
+	details.WriteString("## HTTP")
+	details.WriteString("\n")
+	if i.HTTP.Error != nil {
+		details.WriteString(fmt.Sprintf("- Error   : %v\n", i.HTTP.Error))
+		return
+	}
+	status := fmt.Sprintf("%d %s", i.HTTP.Status, http.StatusText(i.HTTP.Status))
+	if i.HTTP.Status >= 500 {
+		status = fmt.Sprintf("**%s**", status)
+	}
+	details.WriteString(fmt.Sprintf("- Status  : %s (%s %s)\n", status, i.HTTP.Method, i.HTTP.Proto))
+	if i.HTTP.Redirect() {
+		details.WriteString(fmt.Sprintf("- Redirect: %s\n", i.HTTP.Location))
+	}
+	if i.HTTP.HSTS != nil {
+		details.WriteString(fmt.Sprintf("- HSTS    : %s\n", i.HTTP.HSTS))
+	} else {
+		details.WriteString("- HSTS    : **missing**\n")
+	}
+	if i.HTTP.Server != "" {
+		details.WriteString(fmt.Sprintf("- Server  : %s\n", i.HTTP.Server))
+	}
+}
+
+// writeProtocol renders the negotiated parameters and the scan results,
+// flagging the deprecated ones
+func writeProtocol(details *strings.Builder, i domains.Response) {
+	flag := func(name string, deprecated bool) string {
+		if deprecated {
+			return fmt.Sprintf("**%s (deprecated)**", name)
+		}
+		return name
+	}
+	details.WriteString("## Protocol")
+	details.WriteString("\n")
+	details.WriteString(fmt.Sprintf("- Version     : %s\n", flag(domains.VersionName(i.TLSVersion), domains.DeprecatedVersion(i.TLSVersion))))
+	details.WriteString(fmt.Sprintf("- Cipher suite: %s\n", flag(domains.SuiteName(i.CipherSuite), domains.WeakSuite(i.CipherSuite))))
+	if i.ALPN != "" {
+		details.WriteString(fmt.Sprintf("- ALPN        : %s\n", i.ALPN))
+	}
+	if i.Scan == nil {
+		return
+	}
+	details.WriteString("### Accepted versions")
+	details.WriteString("\n")
+	for _, v := range i.Scan.Versions {
+		details.WriteString(fmt.Sprintf("- %s\n", flag(domains.VersionName(v), domains.DeprecatedVersion(v))))
+	}
+	if len(i.Scan.Suites) > 0 {
+		details.WriteString("### Accepted cipher suites")
+		details.WriteString("\n")
+		for _, s := range i.Scan.Suites {
+			details.WriteString(fmt.Sprintf("- %s\n", flag(s.String(), domains.WeakSuite(s.Suite))))
+		}
+	}
+}
+
+// writeBackends renders the result of each resolved address of a hostname
+func writeBackends(details *strings.Builder, i domains.Response) {
+	details.WriteString(fmt.Sprintf("## Backends: %d", len(i.Backends)))
+	details.WriteString("\n")
+	if i.SerialMismatch() {
+		details.WriteString("\n**Warning: backends serve different certificates**\n\n")
+	}
+	for _, b := range i.Backends {
+		if b.Error != nil {
+			details.WriteString(fmt.Sprintf("- %s: error %s\n", b.Address, b.KnownError()))
+		} else {
+			details.WriteString(fmt.Sprintf("- %s: serial %s, expires %s\n", b.Address, b.SerialNumber.Text(16), b.Expiry().Format("2006-01-02")))
+		}
+	}
+	details.WriteString("\n")
+}
+
+// writeChain renders each link of a chain with its own validity window
+func writeChain(details *strings.Builder, title string, chain []*x509.Certificate, expiring *x509.Certificate) {
+	if len(chain) == 0 {
+		return
+	}
+	details.WriteString(fmt.Sprintf("## %s:", title))
+	details.WriteString("\n")
+	for k, c := range chain {
+		details.WriteString(fmt.Sprintf("%d. %s\n", k, c.Subject.CommonName))
+		details.WriteString(fmt.Sprintf("    - Issuer    : %s\n", c.Issuer.CommonName))
+		details.WriteString(fmt.Sprintf("    - Not before: %v\n", c.NotBefore))
+		if c == expiring {
+			details.WriteString(fmt.Sprintf("    - Not after : **%v (first to expire)**\n", c.NotAfter))
+		} else {
+			details.WriteString(fmt.Sprintf("    - Not after : %v\n", c.NotAfter))
+		}
+		info := domains.NewCertInfo(c)
+		details.WriteString(fmt.Sprintf("    - Key       : %s\n", keyDescription(info)))
+		details.WriteString(fmt.Sprintf("    - Signature : %s\n", info.SignatureAlgorithm))
+		if info.IsCA {
+			details.WriteString("    - CA        : yes\n")
+		}
+		if len(info.KeyUsage) > 0 {
+			details.WriteString(fmt.Sprintf("    - Key usage : %s\n", strings.Join(info.KeyUsage, ", ")))
+		}
+		if len(info.ExtKeyUsage) > 0 {
+			details.WriteString(fmt.Sprintf("    - Ext usage : %s\n", strings.Join(info.ExtKeyUsage, ", ")))
+		}
+	}
+}
+
+// keyDescription renders the algorithm, size and curve of a public key
+func keyDescription(info domains.CertInfo) string {
+	key := fmt.Sprintf("%s %d bits", info.KeyAlgorithm, info.KeySize)
+	if info.Curve != "" {
+		key += fmt.Sprintf(" (%s)", info.Curve)
+	}
+	return key
+}
+
+// writeFindings renders the policy violations of the presented chain
+func writeFindings(details *strings.Builder, findings []domains.Finding) {
+	if len(findings) == 0 {
+		return
+	}
+	details.WriteString(fmt.Sprintf("## Findings: %d", len(findings)))
+	details.WriteString("\n")
+	for _, f := range findings {
+		message := f.Message
+		if f.Severity == domains.FindingCritical {
+			message = fmt.Sprintf("**%s**", message)
+		}
+		details.WriteString(fmt.Sprintf("- %s: %s (%s)\n", f.Severity, message, f.Subject))
+	}
+}
+
 func (m domainDetails) view(h, w int) string {
 	str := m.viewport.View()
 	content := lipgloss.Place(
@@ -1,9 +1,8 @@
 package ui
 
 import (
-	"bufio"
+	"context"
 	"fmt"
-	"os"
 	"sort"
 	"strings"
 	"time"
@@ -28,36 +27,22 @@ var (
 )
 
 type config struct {
-	Timeout      int
-	Silent       bool
-	ChefRoot     string
-	FilesQuery   map[string]string
-	DomainsQuery map[string][]string
-	EnvQuery     []string
-	envStrWidth  int
-	detailView   bool
-	exportInput  bool
-	exportDone   bool
-	report       string
+	EnvQuery    []string
+	envStrWidth int
+	detailView  bool
+	exportInput bool
+	exportDone  bool
+	exportErr   error
+	report      string
 }
 
-func newConfig(timeout int, silent bool, files map[string]string, customList map[string][]string, envs []string) *config {
+func newConfig(envs []string) *config {
 	cfg := &config{
-		Timeout:      timeout,
-		Silent:       silent,
-		FilesQuery:   files,
-		DomainsQuery: customList,
-		EnvQuery:     envs,
-		report:       domains.DefaultReportFile,
+		EnvQuery: envs,
+		report:   domains.DefaultReportFile,
 	}
-	for f := range files {
-		w := utf8.RuneCountInString(f)
-		if w > cfg.envStrWidth {
-			cfg.envStrWidth = w
-		}
-	}
-	for f := range customList {
-		w := utf8.RuneCountInString(f)
+	for _, e := range envs {
+		w := utf8.RuneCountInString(e)
 		if w > cfg.envStrWidth {
 			cfg.envStrWidth = w
 		}
@@ -67,33 +52,46 @@ func newConfig(timeout int, silent bool, files map[string]string, customList map
 }
 
 type processor struct {
+	ctx       context.Context
+	cancel    context.CancelFunc
+	runner    *domains.Runner
 	queries   map[string]int
 	processed map[string]int
 	done      bool
-	ch        chan domains.Response
+	ch        <-chan domains.Response
 }
 
-func newProc(envs []string) *processor {
-	var proc processor
-
-	proc.queries = make(map[string]int)
-	proc.processed = make(map[string]int)
-	proc.ch = make(chan domains.Response)
-
-	for _, e := range envs {
-		proc.queries[e] = 0
+func newProc(ctx context.Context, runner *domains.Runner) *processor {
+	ctx, cancel := context.WithCancel(ctx)
+	return &processor{
+		ctx:       ctx,
+		cancel:    cancel,
+		runner:    runner,
+		queries:   runner.Totals(),
+		processed: make(map[string]int),
 	}
-	return &proc
 }
 
-func waitForResponse(resp chan domains.Response) tea.Cmd {
+func waitForResponse(resp <-chan domains.Response) tea.Cmd {
 	return func() tea.Msg {
-		return domains.Response(<-resp)
+		r, ok := <-resp
+		if !ok {
+			return procDone{}
+		}
+		return r
 	}
 }
 
+// progressTick refreshes the progress view while probes are in flight
+func progressTick() tea.Cmd {
+	return tea.Tick(250*time.Millisecond, func(_ time.Time) tea.Msg {
+		return progressTickMsg{}
+	})
+}
+
 type procDone struct{}
 type exportDone struct{}
+type progressTickMsg struct{}
 
 type Model struct {
 	cfg  *config
@@ -106,24 +104,18 @@ type Model struct {
 	exportFile   textinput.Model
 }
 
-func NewModel(timeout int, silent bool, files map[string]string, customList map[string][]string) Model {
-	var (
-		environments []string
-		progressBars []progress.Model
-	)
+// NewModel builds the TUI consuming the responses of runner, quitting
+// cancels the probes still in flight
+func NewModel(ctx context.Context, runner *domains.Runner) Model {
+	var progressBars []progress.Model
 
-	for e := range files {
-		environments = append(environments, e)
+	environments := runner.Environments()
+	for range environments {
 		progressBars = append(progressBars, progress.New(progress.WithScaledGradient(randomcolor.GetRandomColorInHex(), "#00ff00")))
 	}
 
-	for e := range customList {
-		environments = append(environments, e)
-		progressBars = append(progressBars, progress.New(progress.WithScaledGradient(randomcolor.GetRandomColorInHex(), "#00ff00")))
-	}
-
-	cfg := newConfig(timeout, silent, files, customList, environments)
-	proc := newProc(environments)
+	cfg := newConfig(environments)
+	proc := newProc(ctx, runner)
 	keys := newListKeyMap()
 
 	lst := list.New([]list.Item{}, itemDelegate{}, 0, 0)
@@ -161,43 +153,13 @@ func NewModel(timeout int, silent bool, files map[string]string, customList map[
 }
 
 func (m Model) Init() tea.Cmd {
-	log.Debug().Msgf("Init: file queries  : %v", m.cfg.FilesQuery)
-	log.Debug().Msgf("Init: custom queries: %v", m.cfg.DomainsQuery)
-	for env, target := range m.cfg.FilesQuery {
-		expandedPath := os.ExpandEnv(target)
-		_, err := os.Stat(expandedPath)
-		if err != nil {
-			log.Fatal().Msgf("Error can't find %s directory: %v", env, err)
-		}
+	log.Debug().Msgf("Init: queries: %v", m.proc.queries)
+	m.proc.ch = m.proc.runner.Start(m.proc.ctx)
 
-		file, err := os.Open(expandedPath)
-		if err != nil {
-			log.Fatal().Err(err)
-		}
-		scanner := bufio.NewScanner(file)
-		for scanner.Scan() {
-			domain := scanner.Text()
-			if len(strings.TrimSpace(domain)) == 0 {
-				continue
-			}
-			m.proc.queries[env] += 1
-			go domains.TestDomain(domain, env, m.cfg.Timeout, m.proc.ch)
-		}
-	}
-	for env, target := range m.cfg.DomainsQuery {
-		for _, domain := range target {
-			m.proc.queries[env] += 1
-			go domains.TestDomain(domain, env, m.cfg.Timeout, m.proc.ch)
-		}
-	}
-
-	var fullscreen tea.Cmd
-	if !m.cfg.Silent {
-		fullscreen = tea.EnterAltScreen
-	}
 	return tea.Batch(
-		fullscreen,
+		tea.EnterAltScreen,
 		waitForResponse(m.proc.ch),
+		progressTick(),
 	)
 }
 
@@ -211,7 +173,7 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		m.list.SetSize(msg.Width-h, msg.Height-w)
 		// size progress bars view
 		for k := range m.cfg.EnvQuery {
-			m.progressBars[k].Width = msg.Width - (m.cfg.envStrWidth + 20)
+			m.progressBars[k].Width = msg.Width - (m.cfg.envStrWidth + 32)
 		}
 		// size viewport
 		m.details.viewport.Width = msg.Width
@@ -231,7 +193,7 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 					} else {
 						m.cfg.report = domains.DefaultReportFile
 					}
-					m.exportResults(false)
+					m.cfg.exportErr = m.exportResults()
 
 					m.ListCursorsEnabled(true)
 					m.cfg.exportInput = false
@@ -257,6 +219,7 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 
 		switch {
 		case msg.String() == "ctrl+c" || msg.String() == "q":
+			m.proc.cancel()
 			return m, tea.Quit
 		case key.Matches(msg, m.keys.toggleDetails):
 			i := m.list.SelectedItem()
@@ -275,28 +238,10 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 	case domains.Response:
 		m.proc.processed[msg.Environment] += 1
 		m.list.InsertItem(0, msg)
-
-		done := true
-		for env := range m.proc.queries {
-			if m.proc.queries[env] != m.proc.processed[env] {
-				done = false
-				break
-			}
-		}
-		if done {
-			r := func() tea.Msg {
-				return procDone{}
-			}
-			return m, r
-		}
+		// The runner closes the channel once all targets are processed
 		return m, waitForResponse(m.proc.ch)
 
 	case procDone:
-		if m.cfg.Silent {
-			m.exportResults(true)
-			return m, tea.Quit
-		}
-
 		m.proc.done = true
 		items := m.list.Items()
 		now := time.Now()
@@ -304,8 +249,8 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 		sort.Slice(items, func(i, j int) bool {
 			// We want to move errors (null date value) to the end of list
 			// adding 99 years there - XXX find a more elegant way
-			iDate := items[i].(domains.Response).NotAfter
-			jDate := items[j].(domains.Response).NotAfter
+			iDate := items[i].(domains.Response).Expiry()
+			jDate := items[j].(domains.Response).Expiry()
 			if iDate.IsZero() {
 				iDate = now.AddDate(99, 0, 0)
 			}
@@ -322,6 +267,12 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 	case exportDone:
 		m.cfg.exportDone = false
 		return m, nil
+
+	case progressTickMsg:
+		if m.proc.done {
+			return m, nil
+		}
+		return m, progressTick()
 	}
 	// This also call our delegate's update function.
 	newListModel, cmd := m.list.Update(msg)
@@ -342,10 +293,6 @@ func (m Model) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
 }
 
 func (m Model) View() string {
-	if m.cfg.Silent {
-		return ""
-	}
-
 	var str strings.Builder
 	if m.cfg.detailView {
 		str.WriteString(detailStyle.Render(m.details.view(m.list.Height(), m.list.Width())))
@@ -357,31 +304,39 @@ func (m Model) View() string {
 			str.WriteString(lipgloss.NewStyle().Foreground(lipgloss.Color("11")).Render("Report file: ") + m.exportFile.View())
 		}
 		if m.cfg.exportDone {
-			str.WriteString(lipgloss.NewStyle().Foreground(lipgloss.Color("10")).Render("Export successful!"))
+			if m.cfg.exportErr != nil {
+				str.WriteString(lipgloss.NewStyle().Foreground(lipgloss.Color("9")).Render(fmt.Sprintf("Export failed: %v", m.cfg.exportErr)))
+			} else {
+				str.WriteString(lipgloss.NewStyle().Foreground(lipgloss.Color("10")).Render("Export successful!"))
+			}
 		}
 		str.WriteString(appStyle.Render(m.list.View()))
 		if m.cfg.exportInput {
-			str.WriteString(helpStyle("\n Press Enter to confirm or escape to cancel\n"))
+			str.WriteString(helpStyle("\n Format follows the file extension (.md, .json, .yaml, .csv), press Enter to confirm or escape to cancel\n"))
 		}
 	} else {
-		str.WriteString("\n Doing some work...\n\n")
+		limits := m.proc.runner.Limits
+		str.WriteString(fmt.Sprintf("\n Doing some work... (concurrency %d", limits.Concurrency))
+		if limits.QPS > 0 {
+			str.WriteString(fmt.Sprintf(", %g qps", limits.QPS))
+		}
+		if limits.PerHost > 0 {
+			str.WriteString(fmt.Sprintf(", %d per host", limits.PerHost))
+		}
+		str.WriteString(")\n\n")
 		for k, v := range m.cfg.EnvQuery {
 			progress := float64(m.proc.processed[v]) / float64(m.proc.queries[v])
-			str.WriteString(fmt.Sprintf(" - %*s: %v \n", m.cfg.envStrWidth, v, m.progressBars[k].ViewAs(progress)))
+			str.WriteString(fmt.Sprintf(" - %*s: %v %3d active\n", m.cfg.envStrWidth, v, m.progressBars[k].ViewAs(progress), m.proc.runner.Active(v)))
 		}
-		str.WriteString(helpStyle("\n Press any key to exit\n"))
+		str.WriteString(helpStyle("\n Press q to cancel and exit\n"))
 	}
 	return str.String()
 }
 
-// exportResults exposer results to user
-func (m Model) exportResults(stdOut bool) {
-	items := uniqueItems(m.list.Items())
-	resp := make([]domains.Response, len(items))
-	for k, v := range items {
-		resp[k] = v.(domains.Response)
-	}
-	domains.CreateReport(resp, m.cfg.EnvQuery, m.cfg.report, stdOut)
+// exportResults exposer results to user, the report format follows the file extension
+func (m Model) exportResults() error {
+	format := domains.ReportFormatFromFile(m.cfg.report)
+	return domains.CreateReport(m.Responses(), m.cfg.EnvQuery, m.cfg.report, format, false)
 }
 
 // ListCursorsEnabled manage list default keymap hooks to avoid conflicts
@@ -407,17 +362,34 @@ func uniqueItems(s []list.Item) []list.Item {
 
 	for _, v := range s {
 		log.Debug().Msgf("Duplicate test: %v", v)
-		if !encountered[v.(domains.Response).Domain] {
-			encountered[v.(domains.Response).Domain] = true
+		if !encountered[itemKey(v)] {
+			encountered[itemKey(v)] = true
 		}
 	}
 
 	for _, v := range s {
-		if encountered[v.(domains.Response).Domain] {
+		if encountered[itemKey(v)] {
 			uniqueItems = append(uniqueItems, v)
-			encountered[v.(domains.Response).Domain] = false
+			encountered[itemKey(v)] = false
 		}
 	}
 
 	return uniqueItems
 }
+
+// itemKey identifies a probe, the same endpoint probed with another server
+// or verification name being another one
+func itemKey(v list.Item) string {
+	r := v.(domains.Response)
+	return strings.Join([]string{r.Environment, r.Endpoint(), r.ServerName, r.VerifyName}, "\x00")
+}
+
+// Responses returns the collected results, without duplicates
+func (m Model) Responses() []domains.Response {
+	items := uniqueItems(m.list.Items())
+	resp := make([]domains.Response, len(items))
+	for k, v := range items {
+		resp[k] = v.(domains.Response)
+	}
+	return resp
+}